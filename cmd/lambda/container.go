@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/Vasenti/stori_challenge/internal/application/ports"
+	"github.com/Vasenti/stori_challenge/internal/application/services"
+	"github.com/Vasenti/stori_challenge/internal/config"
+	"github.com/Vasenti/stori_challenge/internal/domain"
+	"github.com/Vasenti/stori_challenge/internal/intrastructure/db"
+	"github.com/Vasenti/stori_challenge/internal/intrastructure/db/reader"
+	"github.com/Vasenti/stori_challenge/internal/intrastructure/db/repositories"
+	"github.com/Vasenti/stori_challenge/internal/intrastructure/parser"
+	"github.com/Vasenti/stori_challenge/internal/intrastructure/templating"
+	"github.com/Vasenti/stori_challenge/internal/logging"
+)
+
+// pingTimeout acota el health check de la conexion en cada invocacion.
+const pingTimeout = 2 * time.Second
+
+// container agrupa las dependencias que se reutilizan entre invocaciones
+// "warm": config, pool de Postgres (con AutoMigrate una sola vez), cliente
+// S3, cache de templates, servicio y dispatcher.
+type container struct {
+	cfg        *config.Config
+	log        *slog.Logger
+	gdb        *gorm.DB
+	s3         *reader.S3Reader
+	templates  *templating.Provider
+	users      ports.UserRepository
+	trxs       ports.TransactionRepository
+	svc        ports.TransactionReportService
+	dispatcher ports.OutboxDispatcher
+	scheduler  *services.ReportScheduler
+}
+
+var (
+	deps   *container
+	depsMu sync.Mutex
+)
+
+// getContainer devuelve el container del cold start. Si la base deja de
+// responder (p.ej. tras un failover o un idle largo) se cierra y se reconstruye.
+func getContainer(ctx context.Context) (*container, error) {
+	depsMu.Lock()
+	defer depsMu.Unlock()
+
+	if deps != nil {
+		err := deps.ping(ctx)
+		if err == nil {
+			return deps, nil
+		}
+		deps.log.WarnContext(ctx, "db health check failed, reconnecting", "error", err)
+		deps.close()
+		deps = nil
+	}
+
+	c, err := newContainer()
+	if err != nil {
+		return nil, err
+	}
+	deps = c
+	return c, nil
+}
+
+func newContainer() (*container, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+
+	log := logging.New(os.Stdout, cfg.LogLevel, cfg.LogFormat)
+	slog.SetDefault(log)
+
+	gdb, err := db.NewGorm(cfg, log)
+	if err != nil {
+		return nil, fmt.Errorf("db: %w", err)
+	}
+
+	s3r, err := reader.NewS3Reader(cfg.S3Region, cfg.S3Endpoint, cfg.S3AccessKey, cfg.S3SecretKey, cfg.S3ForcePathStyle)
+	if err != nil {
+		return nil, fmt.Errorf("s3: %w", err)
+	}
+
+	c := &container{
+		cfg:   cfg,
+		log:   log,
+		gdb:   gdb,
+		s3:    s3r,
+		users: repositories.NewUserRepository(gdb),
+		trxs:  repositories.NewTransactionRepository(gdb),
+	}
+	c.templates = templating.NewProvider(templating.ProviderOptions{
+		Dir:      cfg.ReportTemplateDir,
+		Default:  cfg.ReportTemplatePath,
+		CacheTTL: time.Duration(cfg.ReportTemplateCacheSecs) * time.Second,
+		S3:       c.s3Reader,
+	})
+	c.svc = c.newService(c.templates.Resolve)
+	c.dispatcher = newDispatcher(cfg, gdb, log)
+	c.scheduler = services.NewReportScheduler(c.users, c.svc, cfg.BatchWorkers, log)
+	return c, nil
+}
+
+func (c *container) s3Reader() (ports.Reader, error) { return c.s3, nil }
+
+// newService arma el servicio con resolve como fuente de templates; es
+// barato, las conexiones vienen del container.
+func (c *container) newService(resolve func(ctx context.Context, ref string) (*templating.Template, error)) ports.TransactionReportService {
+	newModel := func(sum domain.MonthlySummary, txs []domain.Transaction, u string, l domain.Locale) templating.Model {
+		model := templating.BuildModel(sum, u, time.Now(), l)
+		model.AddTransactions(txs, templating.DetailOptions{MaxRows: c.cfg.ReportDetailMaxRows, TopN: c.cfg.ReportTopN})
+		model.SetChartMode(c.cfg.ReportCharts)
+		return model
+	}
+	render := func(ctx context.Context, sum domain.MonthlySummary, txs []domain.Transaction, u, t string, l domain.Locale) (string, []domain.EmailAsset, error) {
+		tpl, err := resolve(ctx, t)
+		if err != nil {
+			return "", nil, err
+		}
+		out, err := tpl.Render(newModel(sum, txs, u, l))
+		return out.HTML, out.Inline, err
+	}
+	renderJSON := func(_ context.Context, sum domain.MonthlySummary, txs []domain.Transaction, u string, l domain.Locale) ([]byte, error) {
+		out, err := templating.RenderText(newModel(sum, txs, u, l), templating.FormatJSON)
+		return []byte(out), err
+	}
+
+	return services.NewTransactionReportService(
+		reader.NewAutoReader(c.s3Reader),
+		c.users,
+		repositories.NewAccountRepository(c.gdb),
+		c.trxs,
+		repositories.NewOutboxRepository(c.gdb),
+		repositories.NewReportRunRepository(c.gdb),
+		db.NewTransactor(c.gdb),
+		render,
+		renderJSON,
+		parser.ParseTransactionsCSV,
+		services.StepTimeouts{
+			Read:   time.Duration(c.cfg.SourceTimeoutSecs) * time.Second,
+			Parse:  time.Duration(c.cfg.ParseTimeoutSecs) * time.Second,
+			DB:     time.Duration(c.cfg.DBStepTimeoutSecs) * time.Second,
+			Render: time.Duration(c.cfg.RenderTimeoutSecs) * time.Second,
+		},
+		c.log,
+	)
+}
+
+func (c *container) ping(ctx context.Context) error {
+	sqlDB, err := c.gdb.DB()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	return sqlDB.PingContext(ctx)
+}
+
+func (c *container) close() {
+	if sqlDB, err := c.gdb.DB(); err == nil {
+		_ = sqlDB.Close()
+	}
+}
@@ -2,125 +2,313 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"io"
+	"log/slog"
 	"os"
-	"strings"
 	"time"
 
+	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambda/messages"
+	"github.com/aws/aws-lambda-go/lambdacontext"
 
 	"github.com/Vasenti/stori_challenge/internal/application/ports"
 	"github.com/Vasenti/stori_challenge/internal/application/services"
 	"github.com/Vasenti/stori_challenge/internal/config"
 	"github.com/Vasenti/stori_challenge/internal/domain"
-	"github.com/Vasenti/stori_challenge/internal/intrastructure/db"
-	"github.com/Vasenti/stori_challenge/internal/intrastructure/db/reader"
 	"github.com/Vasenti/stori_challenge/internal/intrastructure/db/repositories"
 	"github.com/Vasenti/stori_challenge/internal/intrastructure/email"
-	"github.com/Vasenti/stori_challenge/internal/intrastructure/parser"
 	"github.com/Vasenti/stori_challenge/internal/intrastructure/templating"
+	"github.com/Vasenti/stori_challenge/internal/intrastructure/unsubscribe"
+	"github.com/Vasenti/stori_challenge/internal/intrastructure/webhook"
+	"github.com/Vasenti/stori_challenge/internal/logging"
+	"github.com/Vasenti/stori_challenge/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"gorm.io/gorm"
 )
 
 type Event struct {
-	Email    string `json:"email"`
-	Src      string `json:"src"`
-	Template string `json:"template,omitempty"`
+	// Action selects the job: "" (default) ingests and reports, "dispatch"
+	// only sends pending outbox messages (e.g. from an EventBridge schedule),
+	// "schedule" queues every opted-in user's monthly report.
+	Action string `json:"action,omitempty"`
+	// Month (YYYY-MM) is the month "schedule" reports; empty means the
+	// previous month in each user's timezone.
+	Month string `json:"month,omitempty"`
+	Email string `json:"email"`
+	Src   string `json:"src"`
+	// Account is the account the CSV is imported into; empty means the user's main account.
+	Account string `json:"account,omitempty"`
+	// Template is a template name, local path or s3:// URI. TemplateHTML
+	// carries the template source inline instead.
+	Template     string `json:"template,omitempty"`
+	TemplateHTML string `json:"template_html,omitempty"`
+	Locale       string `json:"locale,omitempty"`
+	// Channel ("email", "webhook") and WebhookURL override the user's delivery settings.
+	Channel    string `json:"channel,omitempty"`
+	WebhookURL string `json:"webhook_url,omitempty"`
+	// RunKey hace idempotente el reporte: con la misma key (p.ej. una
+	// version de un objeto S3) se encola a lo sumo una vez por usuario.
+	RunKey string `json:"run_key,omitempty"`
 }
 
 type Response struct {
-	OK       bool    `json:"ok"`
+	OK bool `json:"ok"`
+	// Code es el codigo de error (domain.Code) cuando OK es false
+	Code     string  `json:"code,omitempty"`
 	Message  string  `json:"message,omitempty"`
 	Balance  float64 `json:"balance,omitempty"`
 	AvgDebit float64 `json:"avg_debit,omitempty"`
 	AvgCred  float64 `json:"avg_credit,omitempty"`
 }
 
-func isLikelyPath(s string) bool {
-	if s == "" { return false }
-	if strings.HasPrefix(s, "s3://") { return true }
-	if strings.HasPrefix(s, "/") || strings.HasPrefix(s, "./") { return true }
-	if strings.HasSuffix(strings.ToLower(s), ".html") || strings.HasSuffix(strings.ToLower(s), ".tmpl") { return true }
-	return false
+func newDispatcher(cfg *config.Config, gdb *gorm.DB, log *slog.Logger) ports.OutboxDispatcher {
+	return services.NewOutboxDispatcher(
+		repositories.NewOutboxRepository(gdb),
+		map[domain.Channel]ports.Notifier{
+			domain.ChannelEmail: email.NewNotifier(email.NewSMTPSender(cfg), unsubscribeURL(cfg)),
+			domain.ChannelWebhook: webhook.NewNotifier(webhook.Options{
+				Secret:     cfg.WebhookSecret,
+				Timeout:    time.Duration(cfg.WebhookTimeoutSecs) * time.Second,
+				MaxRetries: cfg.WebhookMaxRetries,
+			}),
+		},
+		services.OutboxDispatcherOptions{
+			BatchSize:   cfg.OutboxBatchSize,
+			MaxAttempts: cfg.OutboxMaxAttempts,
+			BackoffBase: time.Duration(cfg.OutboxBackoffBaseSecs) * time.Second,
+			BackoffMax:  time.Duration(cfg.OutboxBackoffMaxSecs) * time.Second,
+		},
+		log,
+	)
 }
 
-func loadTemplate(ctx context.Context, tpl string, rdr ports.Reader) (string, error) {
-	if tpl == "" { // usa default embebido
-		return "", nil
-	}
-	// S3
-	if strings.HasPrefix(tpl, "s3://") {
-		rc, err := rdr.Open(tpl)
-		if err != nil { return "", fmt.Errorf("open template s3: %w", err) }
-		defer rc.Close()
-		b, err := io.ReadAll(rc)
-		if err != nil { return "", fmt.Errorf("read template s3: %w", err) }
-		return string(b), nil
-	}
-	// Ruta local
-	if isLikelyPath(tpl) {
-		b, err := os.ReadFile(tpl)
-		if err != nil { return "", fmt.Errorf("read template file: %w", err) }
-		return string(b), nil
-	}
-	// Si no parece ruta, asumimos HTML inline
-	return tpl, nil
+// unsubscribeURL arma los links firmados de baja; nil (sin headers
+// List-Unsubscribe) si no estan configurados.
+func unsubscribeURL(cfg *config.Config) func(userID string) string {
+	if cfg.UnsubscribeSecret == "" || cfg.UnsubscribeBaseURL == "" {
+		return nil
+	}
+	return unsubscribe.NewLinks(cfg.UnsubscribeSecret, cfg.UnsubscribeBaseURL).URL
 }
 
-func handler(ctx context.Context, e Event) (Response, error) {
-	if e.Email == "" || e.Src == "" {
-		return Response{OK: false, Message: "email and src are required"}, fmt.Errorf("missing email/src")
+// scheduleHandler encola el reporte mensual de cada usuario con opt-in a
+// partir de las transacciones guardadas, y despacha lo encolado. Es
+// idempotente: reintentos o ejecuciones repetidas no reenvian un mes.
+func scheduleHandler(ctx context.Context, month string) (Response, error) {
+	var period domain.Period
+	if month != "" {
+		var err error
+		if period, err = domain.ParseMonth(month, time.Local); err != nil {
+			return Response{}, err
+		}
+	}
+	c, err := getContainer(ctx)
+	if err != nil {
+		return Response{}, err
 	}
-	cfg, err := config.Load()
-	if err != nil { return Response{OK: false, Message: "config error"}, err }
 
-	gdb, err := db.NewGorm(cfg)
-	if err != nil { return Response{OK: false, Message: "db error"}, err }
+	res, err := c.scheduler.Run(ctx, time.Now(), period)
+	if err != nil {
+		return Response{}, err
+	}
+	// Lo que falle queda en el outbox para la accion "dispatch"
+	if _, err := c.dispatcher.Dispatch(ctx); err != nil {
+		c.log.WarnContext(ctx, "dispatch after schedule failed", "error", err)
+	}
+	msg := fmt.Sprintf("reports scheduled (users: %d, queued: %d, already sent: %d, no transactions: %d, failed: %d)",
+		res.Users, res.Queued, res.AlreadySent, res.Empty, res.Failed)
+	if res.Failed > 0 {
+		// Error "internal": Lambda reintenta y solo se reprocesan los que fallaron
+		return Response{}, fmt.Errorf("%s: %d of %d users failed", msg, res.Failed, res.Users)
+	}
+	return Response{OK: true, Message: msg}, nil
+}
 
-	users := repositories.NewUserRepository(gdb)
-	trxs  := repositories.NewTransactionRepository(gdb)
+func dispatchHandler(ctx context.Context) (Response, error) {
+	c, err := getContainer(ctx)
+	if err != nil {
+		return Response{}, err
+	}
 
-	// reader para CSV y (si hace falta) para template en S3
-	var rdr ports.Reader = reader.LocalFileReader{}
-	if strings.HasPrefix(e.Src, "s3://") || strings.HasPrefix(e.Template, "s3://") {
-		s3r, err := reader.NewS3Reader(cfg.S3Region, cfg.S3Endpoint, cfg.S3AccessKey, cfg.S3SecretKey, cfg.S3ForcePathStyle)
-		if err != nil { return Response{OK:false, Message:"s3 reader error"}, err }
-		rdr = s3r
+	res, err := c.dispatcher.Dispatch(ctx)
+	if err != nil {
+		return Response{}, err
 	}
 
-	// Cargar contenido del template (de ruta local, S3 o HTML inline)
-	tplContent, err := loadTemplate(ctx, e.Template, rdr)
-	if err != nil { return Response{OK:false, Message:"template load error"}, err }
+	return Response{
+		OK:      true,
+		Message: fmt.Sprintf("outbox dispatched (sent: %d, retried: %d, dead: %d)", res.Sent, res.Retried, res.Dead),
+	}, nil
+}
+
+// handler asigna el correlation id (request id de Lambda) y registra el
+// error de la invocacion, que Lambda solo devuelve al caller.
+func handler(ctx context.Context, raw json.RawMessage) (any, error) {
+	id := logging.NewCorrelationID()
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		id = lc.AwsRequestID
+	}
+	ctx = logging.WithCorrelationID(ctx, id)
 
-	mailer := email.NewSMTPSender(cfg)
+	start := time.Now()
+	sctx, span := telemetry.Start(ctx, "lambda.invoke", attribute.String("faas.invocation_id", id))
+	resp, err := route(sctx, raw)
+	span.End(err)
 
-	render := func(sum domain.MonthlySummary, u, t string) (string, error) {
-		return templating.Render(sum, u, t, time.Now())
+	// El entorno se congela al volver: hay que empujar spans y metricas ahora
+	defer func() {
+		if err := tel.ForceFlush(ctx); err != nil {
+			slog.WarnContext(ctx, "telemetry flush failed", "error", err)
+		}
+	}()
+
+	if err == nil {
+		slog.InfoContext(ctx, "invocation done", "elapsed", time.Since(start))
+		return resp, nil
+	}
+	code := domain.Code(err)
+	slog.ErrorContext(ctx, "invocation failed", "error", err, "code", code, "elapsed", time.Since(start))
+	if retryable(err) {
+		// errorType lleva el codigo, para los Retry/Catch de Step Functions
+		return nil, messages.InvokeResponse_Error{Message: err.Error(), Type: code}
 	}
+	// Reintentar no cambia el resultado: se responde OK=false sin error para
+	// que Lambda no reintente la invocacion asincrona
+	return Response{OK: false, Code: code, Message: err.Error()}, nil
+}
 
-	svc := services.NewTransactionReportService(
-		rdr,
-		users,
-		trxs,
-		mailer,
-		render,
-		parser.ParseTransactionsCSV,
-	)
+// retryable indica si un error puede resolverse reintentando (base caida,
+// errores inesperados). Input invalido, source inexistente, template roto o
+// email rechazado fallan igual en cada intento.
+func retryable(err error) bool {
+	switch domain.Code(err) {
+	case "db_unavailable", "internal":
+		return true
+	}
+	return false
+}
+
+// route acepta el Event propio, notificaciones S3 ObjectCreated y eventos
+// S3 entregados por SQS.
+func route(ctx context.Context, raw json.RawMessage) (any, error) {
+	var probe struct {
+		Records []struct {
+			EventSource string `json:"eventSource"`
+		} `json:"Records"`
+		Source     string `json:"source"`
+		DetailType string `json:"detail-type"`
+	}
+	// Una regla programada de EventBridge sin input propio manda el evento
+	// "Scheduled Event" tal cual: se trata como "schedule"
+	err := json.Unmarshal(raw, &probe)
+	if err == nil && probe.Source == "aws.events" && probe.DetailType == "Scheduled Event" {
+		return scheduleHandler(ctx, "")
+	}
+	if err == nil && len(probe.Records) > 0 {
+		switch probe.Records[0].EventSource {
+		case "aws:sqs":
+			var e events.SQSEvent
+			if err := json.Unmarshal(raw, &e); err != nil {
+				return nil, err
+			}
+			return sqsHandler(ctx, e)
+		case "aws:s3":
+			var e events.S3Event
+			if err := json.Unmarshal(raw, &e); err != nil {
+				return nil, err
+			}
+			return s3Handler(ctx, e)
+		}
+	}
+
+	var e Event
+	if err := json.Unmarshal(raw, &e); err != nil {
+		return nil, domain.Wrap("decode event", domain.ErrValidation, err)
+	}
+	return reportHandler(ctx, e)
+}
 
-	if err := svc.Process(ctx, e.Email, e.Src, tplContent); err != nil {
-		return Response{OK: false, Message: err.Error()}, err
+func reportHandler(ctx context.Context, e Event) (Response, error) {
+	switch e.Action {
+	case "dispatch":
+		return dispatchHandler(ctx)
+	case "schedule":
+		return scheduleHandler(ctx, e.Month)
+	}
+	if e.Email == "" || e.Src == "" {
+		return Response{}, domain.Errorf(domain.ErrValidation, "email and src are required")
+	}
+	c, err := getContainer(ctx)
+	if err != nil {
+		return Response{}, err
+	}
+	if e.Email, err = domain.ParseEmail(e.Email, c.cfg.EmailCaseInsensitiveLocal); err != nil {
+		return Response{}, err
 	}
 
-	sum, err := trxs.GetMonthlySummary(ctx, e.Email)
-	if err != nil { return Response{OK: true, Message: "email sent (summary fetch failed)"}, nil }
+	// Template: nombre / ruta local / s3:// resuelto por el provider del
+	// container, o HTML inline con un servicio propio para esta invocacion
+	svc := c.svc
+	if e.TemplateHTML != "" {
+		tpl, err := templating.Inline(e.TemplateHTML)
+		if err != nil {
+			return Response{}, err
+		}
+		svc = c.newService(func(context.Context, string) (*templating.Template, error) { return tpl, nil })
+	}
+
+	ch, err := domain.ParseChannel(e.Channel)
+	if err != nil {
+		return Response{}, err
+	}
+	opts := ports.ReportOptions{Account: e.Account, Template: e.Template, Locale: e.Locale, Channel: ch, WebhookURL: e.WebhookURL, RunKey: e.RunKey}
+	if err := svc.Process(ctx, e.Email, e.Src, opts); err != nil {
+		return Response{}, err
+	}
+
+	// Best-effort immediate delivery; failures stay queued for the "dispatch" action.
+	// Only this invocation's message counts, not others' pending ones.
+	msg := "report sent"
+	if res, err := c.dispatcher.Dispatch(ctx); err != nil || res.Run(logging.CorrelationID(ctx)).Sent == 0 {
+		msg = "report queued"
+	}
+
+	u, err := c.users.GetByEmail(ctx, e.Email)
+	var sum domain.MonthlySummary
+	if err == nil {
+		sum, err = c.trxs.GetMonthlySummary(ctx, u.ID, domain.Period{})
+	}
+	if err != nil {
+		return Response{OK: true, Message: msg + " (summary fetch failed)"}, nil
+	}
 
 	return Response{
 		OK:       true,
-		Message:  "email sent",
+		Message:  msg,
 		Balance:  sum.BalanceTotal,
 		AvgDebit: sum.AvgDebit,
 		AvgCred:  sum.AvgCredit,
 	}, nil
 }
 
-func main() { lambda.Start(handler) }
+// tel exporta trazas y metricas por OTLP; sin endpoint configurado no hace nada.
+var tel *telemetry.Provider
+
+func main() {
+	// Hasta que el container cargue la config completa; la config parcial alcanza para el logger
+	cfg, _ := config.Load()
+	slog.SetDefault(logging.New(os.Stdout, cfg.LogLevel, cfg.LogFormat))
+
+	var err error
+	tel, err = telemetry.Setup(context.Background(), telemetry.Options{
+		ServiceName: cfg.ServiceName,
+		OTLP:        cfg.OTLPEndpoint != "",
+	})
+	if err != nil {
+		slog.Error("telemetry setup failed", "error", err)
+		os.Exit(1)
+	}
+	lambda.Start(handler)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/Vasenti/stori_challenge/internal/domain"
+)
+
+// s3Handler ingiere cada CSV creado en el evento. Todos los registros se
+// procesan; si alguno fallo con un error reintentable la invocacion falla al
+// final para que Lambda reintente el evento, y los objetos que ya se
+// procesaron no se reportan de nuevo (cada uno reclama su run key). Los demas
+// errores (CSV invalido, usuario desconocido, ...) se registran y se descartan.
+func s3Handler(ctx context.Context, e events.S3Event) (Response, error) {
+	processed, rejected := 0, 0
+	var retry error
+	for _, rec := range e.Records {
+		ok, err := handleS3Record(ctx, rec)
+		if err != nil && retryable(err) {
+			slog.ErrorContext(ctx, "s3 record failed", "error", err, "code", domain.Code(err))
+			retry = cmp.Or(retry, err)
+			continue
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "s3 record rejected", "error", err, "code", domain.Code(err))
+			rejected++
+			continue
+		}
+		if ok {
+			processed++
+		}
+	}
+	if retry != nil {
+		return Response{}, retry
+	}
+	return Response{OK: rejected == 0, Message: fmt.Sprintf("%d objects processed, %d rejected", processed, rejected)}, nil
+}
+
+// sqsHandler procesa eventos S3 entregados por SQS y reporta solo los
+// mensajes con errores reintentables (ReportBatchItemFailures) para que SQS
+// reintente esos; los demas se descartan. Un mensaje con varios registros
+// se reintenta entero, pero los objetos ya procesados no se reportan de nuevo.
+func sqsHandler(ctx context.Context, e events.SQSEvent) (events.SQSEventResponse, error) {
+	var resp events.SQSEventResponse
+	for _, msg := range e.Records {
+		var s3e events.S3Event
+		if err := json.Unmarshal([]byte(msg.Body), &s3e); err != nil {
+			// No va a parsear en ningun reintento: se descarta
+			slog.ErrorContext(ctx, "sqs message is not an s3 event", "sqs_message_id", msg.MessageId, "error", err, "code", "validation")
+			continue
+		}
+		// Mensajes sin Records (p.ej. s3:TestEvent) se descartan
+		retry := false
+		for _, rec := range s3e.Records {
+			if _, err := handleS3Record(ctx, rec); err != nil {
+				slog.ErrorContext(ctx, "sqs message failed", "sqs_message_id", msg.MessageId, "error", err, "code", domain.Code(err))
+				// Solo se devuelven a la cola los que pueden salir bien al reintentar
+				retry = retry || retryable(err)
+			}
+		}
+		if retry {
+			resp.BatchItemFailures = append(resp.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: msg.MessageId})
+		}
+	}
+	return resp, nil
+}
+
+// handleS3Record ingiere un objeto nuevo; devuelve false si el registro no
+// aplica (otro tipo de evento o un archivo que no es CSV).
+func handleS3Record(ctx context.Context, rec events.S3EventRecord) (bool, error) {
+	key := rec.S3.Object.URLDecodedKey
+	if !strings.HasPrefix(rec.EventName, "ObjectCreated:") || !strings.EqualFold(path.Ext(key), ".csv") {
+		slog.InfoContext(ctx, "s3 record skipped", "event", rec.EventName, "bucket", rec.S3.Bucket.Name, "key", key)
+		return false, nil
+	}
+	src := "s3://" + rec.S3.Bucket.Name + "/" + key
+
+	c, err := getContainer(ctx)
+	if err != nil {
+		return false, err
+	}
+	email, err := resolveEmail(ctx, c, src, key)
+	if err != nil {
+		return false, err
+	}
+
+	// La version del objeto es la run key: un reintento del evento no vuelve
+	// a encolar el reporte, y subir un CSV nuevo con la misma key si
+	var runKey string
+	if etag := rec.S3.Object.ETag; etag != "" {
+		runKey = src + "#" + etag
+	}
+	if _, err := reportHandler(ctx, Event{Email: email, Src: src, RunKey: runKey}); err != nil {
+		return false, domain.Wrap(src, nil, err)
+	}
+	return true, nil
+}
+
+// resolveEmail busca el usuario del objeto en su metadata
+// (x-amz-meta-<S3_EMAIL_KEY>), luego en sus tags y por ultimo en la key:
+// el segmento mas cercano al archivo que contenga "@"
+// (incoming/ana@example.com/2025-10.csv o incoming/ana@example.com.csv).
+func resolveEmail(ctx context.Context, c *container, src, key string) (string, error) {
+	metadata, tags, err := c.s3.Attributes(ctx, src)
+	if err != nil {
+		c.log.WarnContext(ctx, "reading s3 metadata/tags failed", "source", src, "error", err)
+	}
+	if email := strings.TrimSpace(metadata[strings.ToLower(c.cfg.S3EmailKey)]); email != "" {
+		return email, nil
+	}
+	if email := strings.TrimSpace(tags[c.cfg.S3EmailKey]); email != "" {
+		return email, nil
+	}
+	if email := emailFromKey(key); email != "" {
+		return email, nil
+	}
+	return "", domain.Errorf(domain.ErrValidation, "%s: no user email in metadata, tags or key", src)
+}
+
+func emailFromKey(key string) string {
+	segments := strings.Split(key, "/")
+	last := len(segments) - 1
+	segments[last] = strings.TrimSuffix(segments[last], path.Ext(segments[last]))
+	for i := last; i >= 0; i-- {
+		if strings.Contains(segments[i], "@") {
+			return segments[i]
+		}
+	}
+	return ""
+}
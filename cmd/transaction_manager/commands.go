@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Vasenti/stori_challenge/internal/domain"
+)
+
+// program is the name the CLI is installed and completed as.
+const program = "transaction_manager"
+
+// command is a node of the CLI tree: a leaf runs, a group dispatches to its
+// subcommands.
+type command struct {
+	name    string
+	summary string
+	// flags declares a leaf's flags on fs and returns the values they parse
+	// into; run gets those values once they are parsed and checked, and the
+	// env its setup prepared.
+	flags func(fs *flag.FlagSet) flagValues
+	run   func(ctx context.Context, e *env, v flagValues) error
+	setup setup
+	sub   []*command
+	// hidden commands still run but are left out of help and completion;
+	// they keep the old top-level names working.
+	hidden bool
+}
+
+// flagValues holds a command's flags. define declares them on fs; check
+// validates them once fs is parsed, before setup touches the config or the DB.
+type flagValues interface {
+	define(fs *flag.FlagSet)
+	check(fs *flag.FlagSet) error
+}
+
+// leaf builds a command whose flags are the fields of F.
+func leaf[F any, PF interface {
+	*F
+	flagValues
+}](name, summary string, s setup, run func(context.Context, *env, PF) error) *command {
+	return &command{
+		name:    name,
+		summary: summary,
+		setup:   s,
+		flags: func(fs *flag.FlagSet) flagValues {
+			v := PF(new(F))
+			v.define(fs)
+			return v
+		},
+		run: func(ctx context.Context, e *env, v flagValues) error { return run(ctx, e, v.(PF)) },
+	}
+}
+
+// noFlags is the flag values of commands with only the global flags.
+type noFlags struct{}
+
+func (*noFlags) define(*flag.FlagSet)      {}
+func (*noFlags) check(*flag.FlagSet) error { return nil }
+
+// globals are the flags every command accepts; they are layered over the
+// environment and the config file by readConfig.
+var globals struct {
+	config    string
+	logLevel  string
+	logFormat string
+}
+
+func root() *command {
+	users := []*command{
+		leaf("recipients", "Show or replace who gets a copy of a user's reports", withDB, runRecipients),
+		leaf("locale", "Show or set a user's report locale", withDB, runLocale),
+		leaf("channel", "Show or set a user's delivery channel", withDB, runChannel),
+		leaf("profile", "Show or update a user's profile, email and report opt-in", withDB, runProfile),
+		leaf("accounts", "List or add a user's accounts", withDB, runAccounts),
+		leaf("normalize-emails", "Canonicalise stored emails and merge duplicate users", withDB, runNormalizeEmails),
+	}
+	validateTemplate := leaf("template", "Parse a template and dry-run it against a sample report", noSetup, runValidateTemplate)
+
+	cmds := []*command{
+		leaf("process", "Ingest a CSV and queue the report (the default command)", withTelemetry, runProcess),
+		leaf("ingest", "Import a CSV into a user's account without reporting", withTelemetry, runIngest),
+		leaf("report", "Queue a user's report from stored transactions and send it", withTelemetry, runReport),
+		leaf("export", "Print a CSV's report as text, md or json without storing it", withTelemetry, runExport),
+		leaf("summary", "Print a user's stored summary as a table or JSON", withDB, runSummary),
+		{name: "transactions", summary: "Query a user's stored transactions", sub: []*command{
+			leaf("list", "List transactions by date, amount and sign, a page at a time", withDB, runTransactionsList),
+		}},
+		leaf("batch", "Process many users from a manifest or an S3 prefix", withTelemetry, runBatch),
+		leaf("schedule", "Queue last month's report for every opted-in user", withTelemetry, runSchedule),
+		leaf("dispatch", "Send the pending outbox messages", withTelemetry, runDispatch),
+		leaf("serve", "Serve the unsubscribe endpoint", withTelemetry, runServe),
+		leaf("migrate", "Bring the database schema up to date", withDB, runMigrate),
+		{name: "users", summary: "Manage users and their settings", sub: users},
+		{name: "validate", summary: "Check inputs before they are used", sub: []*command{
+			validateTemplate,
+			leaf("csv", "Parse a CSV without storing it", noSetup, runValidateCSV),
+		}},
+		leaf("completion", "Print a shell completion script (bash, zsh, fish)", noSetup, runCompletion),
+	}
+	for _, c := range users {
+		cmds = append(cmds, alias(c, c.name))
+	}
+	cmds = append(cmds, alias(validateTemplate, "validate-template"))
+
+	return &command{name: program, summary: "Imports transaction CSVs and sends monthly balance reports.", sub: cmds}
+}
+
+// alias is a hidden copy of c under name.
+func alias(c *command, name string) *command {
+	a := *c
+	a.name, a.hidden = name, true
+	return &a
+}
+
+func (c *command) find(name string) *command {
+	for _, s := range c.sub {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// execute walks args down the tree and runs the command they name. At the
+// root, flags without a command run `process`, as the CLI did before it had
+// subcommands.
+func execute(ctx context.Context, root *command, args []string) error {
+	if len(args) > 0 && args[0] == "__complete" {
+		complete(ctx, root, args[1:], os.Stdout)
+		return nil
+	}
+
+	c, path := root, []string{root.name}
+	for c.sub != nil {
+		if c == root && len(args) > 0 && strings.HasPrefix(args[0], "-") && !isHelp(args[0]) {
+			c, path = root.find("process"), append(path, "process")
+			break
+		}
+		if len(args) == 0 || isHelp(args[0]) {
+			c.help(os.Stdout, path)
+			return nil
+		}
+		if args[0] == "help" {
+			return help(c, path, args[1:])
+		}
+		next := c.find(args[0])
+		if next == nil {
+			c.help(os.Stderr, path)
+			return domain.Errorf(domain.ErrValidation, "unknown command %q", strings.Join(append(path[1:], args[0]), " "))
+		}
+		c, path, args = next, append(path, next.name), args[1:]
+	}
+
+	fs := newFlagSet(path)
+	v := c.flags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := v.check(fs); err != nil {
+		return err
+	}
+	ctx, e, done, err := c.setup.prepare(ctx, v)
+	if err != nil {
+		return err
+	}
+	defer done()
+	return c.run(ctx, e, v)
+}
+
+// help prints the help of the command named by args under c.
+func help(c *command, path []string, args []string) error {
+	for _, a := range args {
+		if c = c.find(a); c == nil {
+			return domain.Errorf(domain.ErrValidation, "unknown command %q", strings.Join(args, " "))
+		}
+		path = append(path, a)
+	}
+	if c.sub != nil {
+		c.help(os.Stdout, path)
+		return nil
+	}
+	fs := flagsOf(c, path)
+	fs.SetOutput(os.Stdout)
+	fs.Usage()
+	return nil
+}
+
+func isHelp(arg string) bool {
+	return arg == "-h" || arg == "-help" || arg == "--help"
+}
+
+// help lists a group's subcommands.
+func (c *command) help(w io.Writer, path []string) {
+	fmt.Fprintf(w, "%s\n\nUsage:\n  %s <command> [flags]\n\nCommands:\n", c.summary, strings.Join(path, " "))
+	for _, s := range c.sub {
+		if !s.hidden {
+			fmt.Fprintf(w, "  %-18s %s\n", s.name, s.summary)
+		}
+	}
+	fmt.Fprintf(w, "\nRun '%s help <command>' for the flags of a command.\n", strings.Join(append([]string{program}, path[1:]...), " "))
+	if len(path) == 1 {
+		fmt.Fprintf(w, "Without a command, the flags run 'process'.\n")
+	}
+}
+
+// newFlagSet returns the flag set of the command at path, with the global
+// flags and a usage message built from the command tree.
+func newFlagSet(path []string) *flag.FlagSet {
+	fs := flag.NewFlagSet(strings.Join(path, " "), flag.ExitOnError)
+	fs.StringVar(&globals.config, "config", "", "Config file (.yaml, .yml or .toml) read under the environment; defaults to CONFIG_FILE")
+	fs.StringVar(&globals.logLevel, "log-level", "", "Overrides LOG_LEVEL (debug, info, warn, error)")
+	fs.StringVar(&globals.logFormat, "log-format", "", "Overrides LOG_FORMAT (json, text)")
+	fs.Usage = func() {
+		w := fs.Output()
+		if c := lookup(path); c != nil {
+			fmt.Fprintf(w, "%s\n\n", c.summary)
+		}
+		fmt.Fprintf(w, "Usage:\n  %s [flags]\n\nFlags:\n", fs.Name())
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func lookup(path []string) *command {
+	if len(path) == 0 {
+		return nil
+	}
+	c := root()
+	for _, name := range path[1:] {
+		if c = c.find(name); c == nil {
+			return nil
+		}
+	}
+	return c
+}
+
+// flagsOf returns the flag set of a leaf command without running it.
+func flagsOf(c *command, path []string) *flag.FlagSet {
+	fs := newFlagSet(path)
+	c.flags(fs)
+	return fs
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Vasenti/stori_challenge/internal/domain"
+)
+
+// The scripts ask the binary for candidates (`transaction_manager __complete
+// <words>...`), so they follow the command tree as it grows.
+const (
+	bashCompletion = `# bash completion for transaction_manager
+_transaction_manager() {
+	local IFS=$'\n'
+	COMPREPLY=($(transaction_manager __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+}
+complete -o default -F _transaction_manager transaction_manager
+`
+	zshCompletion = `#compdef transaction_manager
+autoload -U +X bashcompinit && bashcompinit
+` + bashCompletion
+	fishCompletion = `# fish completion for transaction_manager
+complete -c transaction_manager -a '(transaction_manager __complete (commandline -opc)[2..-1] (commandline -ct) 2>/dev/null)'
+`
+)
+
+// completionFlags are the flags of `completion`: just the shell argument.
+type completionFlags struct {
+	fs    *flag.FlagSet
+	shell string
+}
+
+func (f *completionFlags) define(fs *flag.FlagSet) {
+	f.fs = fs
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Print a shell completion script.\n\nUsage:\n  %s completion bash|zsh|fish\n\n", program)
+		fmt.Fprintf(fs.Output(), "  bash: source <(%[1]s completion bash)\n  zsh:  %[1]s completion zsh > \"${fpath[1]}/_%[1]s\"\n  fish: %[1]s completion fish > ~/.config/fish/completions/%[1]s.fish\n", program)
+	}
+}
+
+func (f *completionFlags) check(fs *flag.FlagSet) error {
+	f.shell = fs.Arg(0)
+	return nil
+}
+
+// runCompletion prints the completion script of a shell.
+func runCompletion(_ context.Context, _ *env, f *completionFlags) error {
+	switch f.shell {
+	case "bash":
+		fmt.Print(bashCompletion)
+	case "zsh":
+		fmt.Print(zshCompletion)
+	case "fish":
+		fmt.Print(fishCompletion)
+	default:
+		f.fs.SetOutput(os.Stderr)
+		f.fs.Usage()
+		return domain.Errorf(domain.ErrValidation, "unknown shell %q", f.shell)
+	}
+	return nil
+}
+
+// complete prints the candidates for the last of words, one per line: the
+// subcommands of the command the previous words name, or its flags when the
+// last word starts with "-".
+func complete(_ context.Context, root *command, words []string, w io.Writer) {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	current := words[len(words)-1]
+
+	c, path := root, []string{root.name}
+	for _, word := range words[:len(words)-1] {
+		if c.sub == nil || strings.HasPrefix(word, "-") {
+			break
+		}
+		next := c.find(word)
+		if next == nil {
+			return
+		}
+		c, path = next, append(path, word)
+	}
+
+	if strings.HasPrefix(current, "-") {
+		if c == root {
+			c, path = root.find("process"), append(path, "process")
+		}
+		if c.sub != nil {
+			return
+		}
+		flagsOf(c, path).VisitAll(func(f *flag.Flag) {
+			if name := "--" + f.Name; strings.HasPrefix(name, current) {
+				fmt.Fprintln(w, name)
+			}
+		})
+		return
+	}
+	for _, s := range c.sub {
+		if !s.hidden && strings.HasPrefix(s.name, current) {
+			fmt.Fprintln(w, s.name)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/Vasenti/stori_challenge/internal/application/ports"
+	"github.com/Vasenti/stori_challenge/internal/domain"
+	"github.com/Vasenti/stori_challenge/internal/logging"
+)
+
+// Process exit codes by error code (see domain.Code). Flag parsing errors
+// also exit with 2, and runs interrupted by SIGINT/SIGTERM with 130.
+var exitCodes = map[string]int{
+	"internal":         1,
+	"validation":       2,
+	"source_not_found": 3,
+	"db_unavailable":   4,
+	"email_rejected":   5,
+	"template_invalid": 6,
+}
+
+var hints = map[string]string{
+	"validation":       "Check the flags, the environment and the CSV contents (-h lists the flags).",
+	"source_not_found": "Check the --src path or S3 URI and the S3 credentials.",
+	"db_unavailable":   "Check DB_HOST, DB_PORT and the DB credentials, and that Postgres is running.",
+	"email_rejected":   "The SMTP server refused the message; check the recipients and SMTP_FROM. It will not be retried.",
+	"template_invalid": "Check the template syntax and the fields it uses; `validate template` lists every problem.",
+}
+
+// exitInterrupted is the conventional status of a process stopped by a signal.
+const exitInterrupted = 130
+
+// exit prints err with a hint for its kind on stderr and exits with the kind's code.
+func exit(err error) {
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintf(os.Stderr, "interrupted: %v\n", err)
+		os.Exit(exitInterrupted)
+	}
+	code := domain.Code(err)
+	fmt.Fprintf(os.Stderr, "error: %v\n", err)
+	if hint := hints[code]; hint != "" {
+		fmt.Fprintln(os.Stderr, hint)
+	}
+	os.Exit(exitCodes[code])
+}
+
+// deadLettered turns messages given up on during a dispatch into an error.
+func deadLettered(res ports.DispatchResult) error {
+	if res.Dead == 0 {
+		return nil
+	}
+	return domain.Wrap(fmt.Sprintf("%d report(s) dead-lettered", res.Dead), nil, res.DeadErr)
+}
+
+// runDeadLettered is deadLettered for the messages queued by the run in ctx.
+// Dead letters of older runs that the same dispatch picked up are only
+// logged: they are not this run's failure.
+func runDeadLettered(ctx context.Context, logger *slog.Logger, res ports.DispatchResult) error {
+	own := res.Run(logging.CorrelationID(ctx))
+	if others := res.Dead - own.Dead; others > 0 {
+		logger.WarnContext(ctx, "dispatch dead-lettered reports of other runs", "dead", others)
+	}
+	return deadLettered(own)
+}
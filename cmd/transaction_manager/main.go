@@ -2,87 +2,1088 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/Vasenti/stori_challenge/internal/application/ports"
 	"github.com/Vasenti/stori_challenge/internal/application/services"
 	"github.com/Vasenti/stori_challenge/internal/config"
 	"github.com/Vasenti/stori_challenge/internal/domain"
+	"github.com/Vasenti/stori_challenge/internal/intrastructure/batch"
 	"github.com/Vasenti/stori_challenge/internal/intrastructure/db"
 	"github.com/Vasenti/stori_challenge/internal/intrastructure/db/reader"
 	"github.com/Vasenti/stori_challenge/internal/intrastructure/db/repositories"
 	"github.com/Vasenti/stori_challenge/internal/intrastructure/email"
 	"github.com/Vasenti/stori_challenge/internal/intrastructure/parser"
 	"github.com/Vasenti/stori_challenge/internal/intrastructure/templating"
+	"github.com/Vasenti/stori_challenge/internal/intrastructure/unsubscribe"
+	"github.com/Vasenti/stori_challenge/internal/intrastructure/webhook"
+	"github.com/Vasenti/stori_challenge/internal/logging"
+	"github.com/Vasenti/stori_challenge/internal/telemetry"
 	"github.com/joho/godotenv"
+	"gorm.io/gorm"
 )
 
 func main() {
 	_ = godotenv.Load()
 
-	var emailTo string
-	var source string
-	var templatePath string
+	// SIGINT/SIGTERM cancel ctx so in-flight downloads, queries and SMTP
+	// sessions stop and the run unwinds; a second signal kills the process.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	context.AfterFunc(ctx, stop)
+	defer stop()
 
-	flag.StringVar(&emailTo, "email", "", "User email to send the report")
-	flag.StringVar(&source, "src", "", "CSV Route (local or s3://bucket/key)")
-	flag.StringVar(&templatePath, "template", "", "HTML Template Path (local path)")
-	flag.Parse()
+	if err := execute(ctx, root(), os.Args[1:]); err != nil {
+		stop()
+		exit(err)
+	}
+}
+
+// processFlags are the flags of `process`.
+type processFlags struct {
+	email, source, account, templateRef, locale, channel, webhookURL, output string
+
+	format templating.Format
+	ch     domain.Channel
+}
+
+func (f *processFlags) define(fs *flag.FlagSet) {
+	fs.StringVar(&f.email, "email", "", "User email to send the report")
+	fs.StringVar(&f.source, "src", "", "CSV Route (local or s3://bucket/key)")
+	fs.StringVar(&f.account, "account", "", "Account the CSV is imported into (defaults to the user's main account)")
+	fs.StringVar(&f.templateRef, "template", "", "Template name, local path or s3://bucket/key (defaults to REPORT_TEMPLATE_PATH or the embedded monthly template)")
+	fs.StringVar(&f.locale, "locale", "", "Report locale (en-US, es-MX); defaults to the user's locale")
+	fs.StringVar(&f.channel, "channel", "", "Delivery channel (email, webhook); defaults to the user's channel")
+	fs.StringVar(&f.webhookURL, "webhook-url", "", "Webhook URL for this run (implies --channel=webhook)")
+	fs.StringVar(&f.output, "output", "html", "Report format: html (queued as email), or text, md or json (a preview of the CSV alone, printed to stdout and not stored)")
+}
+
+func (f *processFlags) check(*flag.FlagSet) error {
+	if f.email == "" || f.source == "" {
+		return domain.Errorf(domain.ErrValidation, "email and src flags are required")
+	}
+	var err error
+	if f.format, err = templating.ParseFormat(f.output); err != nil {
+		return err
+	}
+	if f.format != templating.FormatHTML && (f.templateRef != "" || f.channel != "" || f.webhookURL != "") {
+		return domain.Errorf(domain.ErrValidation, "--template, --channel and --webhook-url only apply to --output=html")
+	}
+	f.ch, err = domain.ParseChannel(f.channel)
+	return err
+}
 
-	if emailTo == "" || source == "" {
-		fmt.Println("email and src flags are required")
-		os.Exit(1)
+func (f *processFlags) emails() []*string { return []*string{&f.email} }
+
+// runProcess ingests one CSV source and queues (or prints) the user's report.
+func runProcess(ctx context.Context, e *env, f *processFlags) error {
+	svc := newReportService(e.cfg, e.db, reader.NewAutoReader(newS3Reader(e.cfg)), e.logger)
+
+	// Text formats print a preview of the CSV's report instead of storing it
+	// and emailing the report.
+	if f.format != templating.FormatHTML {
+		return printReport(ctx, e.cfg, svc, f.email, f.source, ports.ReportOptions{Account: f.account, Locale: f.locale}, f.format)
+	}
+
+	if err := svc.Process(ctx, f.email, f.source, ports.ReportOptions{
+		Account:    f.account,
+		Template:   f.templateRef,
+		Locale:     f.locale,
+		Channel:    f.ch,
+		WebhookURL: f.webhookURL,
+	}); err != nil {
+		return err
 	}
 
-	cfg, err := config.Load()
+	// Try to deliver right away; anything that fails stays in the outbox for `dispatch`.
+	res, err := newDispatcher(e.cfg, e.db, e.logger).Dispatch(ctx)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	return runDeadLettered(ctx, e.logger, res)
+}
+
+// exportFlags are the flags of `export`.
+type exportFlags struct {
+	email, source, account, locale, output string
+
+	format templating.Format
+}
+
+func (f *exportFlags) define(fs *flag.FlagSet) {
+	fs.StringVar(&f.email, "email", "", "User email")
+	fs.StringVar(&f.source, "src", "", "CSV Route (local or s3://bucket/key)")
+	fs.StringVar(&f.account, "account", "", "Account the CSV belongs to (defaults to the user's main account)")
+	fs.StringVar(&f.locale, "locale", "", "Report locale (en-US, es-MX); defaults to the user's locale")
+	fs.StringVar(&f.output, "format", "text", "Report format: text, md or json")
+}
+
+func (f *exportFlags) check(*flag.FlagSet) error {
+	if f.email == "" || f.source == "" {
+		return domain.Errorf(domain.ErrValidation, "email and src flags are required")
+	}
+	var err error
+	if f.format, err = templating.ParseFormat(f.output); err != nil {
+		return err
 	}
+	if f.format == templating.FormatHTML {
+		return domain.Errorf(domain.ErrValidation, "export prints text, md or json; use report to send the HTML report")
+	}
+	return nil
+}
+
+func (f *exportFlags) emails() []*string { return []*string{&f.email} }
 
-	gdb, err := db.NewGorm(cfg)
+// runExport prints the report of one CSV source to stdout without storing
+// the CSV or queueing anything.
+func runExport(ctx context.Context, e *env, f *exportFlags) error {
+	svc := newReportService(e.cfg, e.db, reader.NewAutoReader(newS3Reader(e.cfg)), e.logger)
+	return printReport(ctx, e.cfg, svc, f.email, f.source, ports.ReportOptions{Account: f.account, Locale: f.locale}, f.format)
+}
+
+// printReport prints a preview of source's report in a text format; nothing
+// is stored.
+func printReport(ctx context.Context, cfg *config.Config, svc ports.TransactionReportService, emailTo, source string, opts ports.ReportOptions, format templating.Format) error {
+	data, err := svc.Preview(ctx, emailTo, source, opts)
+	if err != nil {
+		return err
+	}
+	out, err := templating.RenderText(newModel(cfg, data.Summary, data.Transactions, emailTo, data.Locale), format)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	fmt.Print(out)
+	return nil
+}
 
-	users := repositories.NewUserRepository(gdb)
-	transactions := repositories.NewTransactionRepository(gdb)
+// ingestFlags are the flags of `ingest`.
+type ingestFlags struct {
+	email, source, account string
+}
 
-	var rdr ports.Reader = reader.LocalFileReader{}
-	if strings.HasPrefix(source, "s3://") {
-		s3r, err := reader.NewS3Reader(cfg.S3Region, cfg.S3Endpoint, cfg.S3AccessKey, cfg.S3SecretKey, cfg.S3ForcePathStyle)
-		if err != nil { panic(err) }
-		rdr = s3r
+func (f *ingestFlags) define(fs *flag.FlagSet) {
+	fs.StringVar(&f.email, "email", "", "User email")
+	fs.StringVar(&f.source, "src", "", "CSV Route (local or s3://bucket/key)")
+	fs.StringVar(&f.account, "account", "", "Account the CSV is imported into (defaults to the user's main account)")
+}
+
+func (f *ingestFlags) check(*flag.FlagSet) error {
+	if f.email == "" || f.source == "" {
+		return domain.Errorf(domain.ErrValidation, "email and src flags are required")
+	}
+	return nil
+}
+
+func (f *ingestFlags) emails() []*string { return []*string{&f.email} }
+
+// runIngest imports one CSV source into the user's account without
+// queueing a report.
+func runIngest(ctx context.Context, e *env, f *ingestFlags) error {
+	svc := newReportService(e.cfg, e.db, reader.NewAutoReader(newS3Reader(e.cfg)), e.logger)
+	res, err := svc.Ingest(ctx, f.email, f.source, f.account)
+	if err != nil {
+		return err
 	}
+	fmt.Printf("Ingested %s into %s/%s - rows: %d, new: %d\n", res.Source, res.Email, res.Account, res.Rows, res.Inserted)
+	return nil
+}
+
+// reportFlags are the flags of `report`.
+type reportFlags struct {
+	email, month, templateRef, locale, channel, webhookURL string
 
-	mailer := email.NewSMTPSender(cfg)
+	period domain.Period
+	ch     domain.Channel
+}
 
-	var template string
-	if templatePath != "" {
-		b, err := os.ReadFile(templatePath)
+func (f *reportFlags) define(fs *flag.FlagSet) {
+	fs.StringVar(&f.email, "email", "", "User email to send the report")
+	fs.StringVar(&f.month, "month", "", "Month to report, YYYY-MM (defaults to all stored transactions)")
+	fs.StringVar(&f.templateRef, "template", "", "Template name, local path or s3://bucket/key (defaults to REPORT_TEMPLATE_PATH or the embedded monthly template)")
+	fs.StringVar(&f.locale, "locale", "", "Report locale (en-US, es-MX); defaults to the user's locale")
+	fs.StringVar(&f.channel, "channel", "", "Delivery channel (email, webhook); defaults to the user's channel")
+	fs.StringVar(&f.webhookURL, "webhook-url", "", "Webhook URL for this run (implies --channel=webhook)")
+}
+
+func (f *reportFlags) check(*flag.FlagSet) error {
+	if f.email == "" {
+		return domain.Errorf(domain.ErrValidation, "email flag is required")
+	}
+	var err error
+	if f.period, err = parseMonth(f.month); err != nil {
+		return err
+	}
+	f.ch, err = domain.ParseChannel(f.channel)
+	return err
+}
+
+func (f *reportFlags) emails() []*string { return []*string{&f.email} }
+
+// runReport queues the user's report from the transactions already stored
+// and tries to deliver it.
+func runReport(ctx context.Context, e *env, f *reportFlags) error {
+	svc := newReportService(e.cfg, e.db, reader.NewAutoReader(newS3Reader(e.cfg)), e.logger)
+	status, err := svc.Report(ctx, f.email, f.period, ports.ReportOptions{
+		Template:   f.templateRef,
+		Locale:     f.locale,
+		Channel:    f.ch,
+		WebhookURL: f.webhookURL,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Report for %s: %s\n", f.email, status)
+	if status != ports.ReportQueued {
+		return nil
+	}
+
+	// Try to deliver right away; anything that fails stays in the outbox for `dispatch`.
+	res, err := newDispatcher(e.cfg, e.db, e.logger).Dispatch(ctx)
+	if err != nil {
+		return err
+	}
+	return runDeadLettered(ctx, e.logger, res)
+}
+
+// runDispatch sends pending outbox messages; meant to be run on a schedule.
+func runDispatch(ctx context.Context, e *env, _ *noFlags) error {
+	res, err := newDispatcher(e.cfg, e.db, e.logger).Dispatch(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Outbox dispatched - sent: %d, retried: %d, dead: %d\n", res.Sent, res.Retried, res.Dead)
+	return deadLettered(res)
+}
+
+// batchFlags are the flags of `batch`.
+type batchFlags struct {
+	manifest, prefix, summary, templateRef, locale, channel, account string
+	workers                                                          int
+
+	ch domain.Channel
+}
+
+func (f *batchFlags) define(fs *flag.FlagSet) {
+	fs.StringVar(&f.manifest, "manifest", "", "Manifest (CSV with header or JSON array) of email,source[,account,template,locale,channel,webhook_url]; local or s3://")
+	fs.StringVar(&f.prefix, "prefix", "", "S3 prefix laid out as s3://bucket/<prefix>/<email>/*.csv")
+	fs.StringVar(&f.summary, "summary", "batch-summary.csv", "Per-user result file (.csv or .json)")
+	fs.IntVar(&f.workers, "workers", 0, "Users processed concurrently (defaults to BATCH_WORKERS)")
+	fs.StringVar(&f.templateRef, "template", "", "Template for users without one in the manifest")
+	fs.StringVar(&f.locale, "locale", "", "Locale for users without one in the manifest")
+	fs.StringVar(&f.channel, "channel", "", "Channel for users without one in the manifest")
+	fs.StringVar(&f.account, "account", "", "Account for sources without one in the manifest")
+}
+
+func (f *batchFlags) check(*flag.FlagSet) error {
+	if (f.manifest == "") == (f.prefix == "") {
+		return domain.Errorf(domain.ErrValidation, "exactly one of manifest or prefix is required")
+	}
+	var err error
+	f.ch, err = domain.ParseChannel(f.channel)
+	return err
+}
+
+// runBatch processes every user of a manifest or S3 prefix with a bounded
+// worker pool and writes a per-user summary file.
+func runBatch(ctx context.Context, e *env, f *batchFlags) error {
+	cfg, logger := e.cfg, e.logger
+	workers := f.workers
+	if workers <= 0 {
+		workers = cfg.BatchWorkers
+	}
+
+	rdr := reader.NewAutoReader(newS3Reader(cfg))
+	var jobs []ports.BatchJob
+	if f.manifest != "" {
+		rc, err := rdr.Open(ctx, f.manifest)
 		if err != nil {
-			panic(err)
+			return domain.Wrap("open manifest", nil, err)
 		}
-		template = string(b)
+		jobs, err = batch.ReadManifest(rc, batch.FormatOf(f.manifest))
+		rc.Close()
+		if err != nil {
+			return domain.Wrap(f.manifest, domain.ErrValidation, err)
+		}
+	} else {
+		s3r, err := reader.NewS3Reader(cfg.S3Region, cfg.S3Endpoint, cfg.S3AccessKey, cfg.S3SecretKey, cfg.S3ForcePathStyle)
+		if err != nil {
+			return err
+		}
+		uris, err := s3r.List(ctx, f.prefix)
+		if err != nil {
+			return domain.Wrap("list prefix", nil, err)
+		}
+		jobs = batch.JobsFromPrefix(f.prefix, uris)
 	}
+	// A row with an invalid email fails on its own in the summary; the
+	// other users still run.
+	var rejected []ports.BatchResult
+	valid := jobs[:0]
+	for _, job := range jobs {
+		email, err := parseEmail(cfg, job.Email)
+		if err != nil {
+			r := ports.BatchResult{Email: job.Email, Err: err}
+			for _, src := range job.Sources {
+				r.Sources = append(r.Sources, src.Path)
+			}
+			rejected = append(rejected, r)
+			continue
+		}
+		job.Email = email
+		valid = append(valid, job)
+	}
+	jobs = valid
+	for i := range jobs {
+		o := &jobs[i].Options
+		o.Account = f.account
+		if o.Template == "" {
+			o.Template = f.templateRef
+		}
+		if o.Locale == "" {
+			o.Locale = f.locale
+		}
+		if o.Channel == "" && o.WebhookURL == "" {
+			o.Channel = f.ch
+		}
+	}
+
+	results := services.NewBatchRunner(newReportService(cfg, e.db, rdr, logger), workers, logger).Run(ctx, jobs)
+	results = append(results, rejected...)
+	for _, r := range rejected {
+		logger.ErrorContext(ctx, "batch user failed", "user", r.Email, "error", r.Err)
+	}
+
+	out, err := os.Create(f.summary)
+	if err != nil {
+		return err
+	}
+	if err := batch.WriteSummary(out, batch.FormatOf(f.summary), results); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+		}
+	}
+	fmt.Printf("Batch done - users: %d, ok: %d, failed: %d (summary: %s)\n", len(results), len(results)-failed, failed, f.summary)
+
+	// Deliver what was queued; anything that fails stays in the outbox for `dispatch`.
+	res, err := newDispatcher(cfg, e.db, logger).Dispatch(ctx)
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d users failed, see %s", failed, len(results), f.summary)
+	}
+	return runDeadLettered(ctx, logger, res)
+}
+
+// scheduleFlags are the flags of `schedule`.
+type scheduleFlags struct {
+	month   string
+	workers int
+
+	period domain.Period
+}
+
+func (f *scheduleFlags) define(fs *flag.FlagSet) {
+	fs.StringVar(&f.month, "month", "", "Month to report, YYYY-MM (defaults to the previous month in each user's timezone)")
+	fs.IntVar(&f.workers, "workers", 0, "Users processed concurrently (defaults to BATCH_WORKERS)")
+}
+
+func (f *scheduleFlags) check(*flag.FlagSet) error {
+	var err error
+	f.period, err = parseMonth(f.month)
+	return err
+}
+
+// runSchedule queues last month's report of every opted-in user from the
+// stored transactions; meant to run from cron. Reruns never send a user the
+// same month twice.
+func runSchedule(ctx context.Context, e *env, f *scheduleFlags) error {
+	workers := f.workers
+	if workers <= 0 {
+		workers = e.cfg.BatchWorkers
+	}
+
+	svc := newReportService(e.cfg, e.db, reader.NewAutoReader(newS3Reader(e.cfg)), e.logger)
+	res, err := services.NewReportScheduler(repositories.NewUserRepository(e.db), svc, workers, e.logger).Run(ctx, time.Now(), f.period)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Schedule done - users: %d, queued: %d, already sent: %d, no transactions: %d, failed: %d\n",
+		res.Users, res.Queued, res.AlreadySent, res.Empty, res.Failed)
+
+	// Deliver what was queued; anything that fails stays in the outbox for `dispatch`.
+	if _, err := newDispatcher(e.cfg, e.db, e.logger).Dispatch(ctx); err != nil {
+		return err
+	}
+	if res.Failed > 0 {
+		return fmt.Errorf("%d of %d users failed", res.Failed, res.Users)
+	}
+	return nil
+}
+
+// recipientsFlags are the flags of `users recipients`.
+type recipientsFlags struct {
+	email, to, cc, bcc, replyTo string
+
+	rcpts domain.ReportRecipients
+	// replace is whether a recipient flag was given; -email and the global
+	// flags alone just show the recipients.
+	replace bool
+}
 
-	render := func(sum domain.MonthlySummary, u string, t string) (string, error) {
-		return templating.Render(sum, u, t, time.Now())
+func (f *recipientsFlags) define(fs *flag.FlagSet) {
+	fs.StringVar(&f.email, "email", "", "User email whose report recipients are managed")
+	fs.StringVar(&f.to, "to", "", "Extra To addresses (comma separated)")
+	fs.StringVar(&f.cc, "cc", "", "Cc addresses (comma separated)")
+	fs.StringVar(&f.bcc, "bcc", "", "Bcc addresses (comma separated)")
+	fs.StringVar(&f.replyTo, "reply-to", "", "Reply-To address")
+}
+
+func (f *recipientsFlags) check(fs *flag.FlagSet) error {
+	if f.email == "" {
+		return domain.Errorf(domain.ErrValidation, "email flag is required")
+	}
+	var err error
+	if f.rcpts.To, err = parseAddresses(splitList(f.to)); err != nil {
+		return err
+	}
+	if f.rcpts.Cc, err = parseAddresses(splitList(f.cc)); err != nil {
+		return err
+	}
+	if f.rcpts.Bcc, err = parseAddresses(splitList(f.bcc)); err != nil {
+		return err
+	}
+	if replyTo := strings.TrimSpace(f.replyTo); replyTo != "" {
+		if f.rcpts.ReplyTo, err = domain.ParseEmail(replyTo, false); err != nil {
+			return err
+		}
+	}
+	fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "to", "cc", "bcc", "reply-to":
+			f.replace = true
+		}
+	})
+	return nil
+}
+
+func (f *recipientsFlags) emails() []*string { return []*string{&f.email} }
+
+// runRecipients shows or replaces who gets a copy of a user's reports.
+func runRecipients(ctx context.Context, e *env, f *recipientsFlags) error {
+	u, err := userFor(ctx, e.db, f.email, f.replace)
+	if err != nil {
+		return err
+	}
+	if f.replace {
+		u.Recipients = f.rcpts
+		if err := repositories.NewUserRepository(e.db).Update(ctx, u); err != nil {
+			return err
+		}
 	}
+	fmt.Printf("to: %v\ncc: %v\nbcc: %v\nreply-to: %s\n", u.Recipients.To, u.Recipients.Cc, u.Recipients.Bcc, u.Recipients.ReplyTo)
+	return nil
+}
+
+// localeFlags are the flags of `users locale`.
+type localeFlags struct {
+	email, set string
+
+	locale domain.Locale
+}
+
+func (f *localeFlags) define(fs *flag.FlagSet) {
+	fs.StringVar(&f.email, "email", "", "User email")
+	fs.StringVar(&f.set, "set", "", "Locale to store (en-US, es-MX)")
+}
 
-	svc := services.NewTransactionReportService(
+func (f *localeFlags) check(*flag.FlagSet) error {
+	if f.email == "" {
+		return domain.Errorf(domain.ErrValidation, "email flag is required")
+	}
+	if f.set == "" {
+		return nil
+	}
+	var err error
+	f.locale, err = domain.LookupLocale(f.set)
+	return err
+}
+
+func (f *localeFlags) emails() []*string { return []*string{&f.email} }
+
+// runLocale shows or sets the locale a user's reports are rendered in.
+func runLocale(ctx context.Context, e *env, f *localeFlags) error {
+	u, err := userFor(ctx, e.db, f.email, f.locale != "")
+	if err != nil {
+		return err
+	}
+	if f.locale != "" {
+		u.Locale = f.locale
+		if err := repositories.NewUserRepository(e.db).Update(ctx, u); err != nil {
+			return err
+		}
+	}
+	fmt.Println("locale:", domain.ParseLocale(string(u.Locale)))
+	return nil
+}
+
+// channelFlags are the flags of `users channel`.
+type channelFlags struct {
+	email, set, webhookURL string
+
+	ch domain.Channel
+}
+
+func (f *channelFlags) define(fs *flag.FlagSet) {
+	fs.StringVar(&f.email, "email", "", "User email")
+	fs.StringVar(&f.set, "set", "", "Channel to store (email, webhook)")
+	fs.StringVar(&f.webhookURL, "webhook-url", "", "URL the webhook channel posts to")
+}
+
+func (f *channelFlags) check(*flag.FlagSet) error {
+	if f.email == "" {
+		return domain.Errorf(domain.ErrValidation, "email flag is required")
+	}
+	var err error
+	if f.ch, err = domain.ParseChannel(f.set); err != nil {
+		return err
+	}
+	if f.ch == domain.ChannelWebhook && f.webhookURL == "" {
+		return domain.Errorf(domain.ErrValidation, "webhook-url flag is required for the webhook channel")
+	}
+	f.webhookURL, err = domain.ParseWebhookURL(f.webhookURL)
+	return err
+}
+
+func (f *channelFlags) emails() []*string { return []*string{&f.email} }
+
+// runChannel shows or sets how a user's reports are delivered.
+func runChannel(ctx context.Context, e *env, f *channelFlags) error {
+	u, err := userFor(ctx, e.db, f.email, f.ch != "")
+	if err != nil {
+		return err
+	}
+	if f.ch != "" {
+		u.Channel, u.WebhookURL = f.ch, f.webhookURL
+		if err := repositories.NewUserRepository(e.db).Update(ctx, u); err != nil {
+			return err
+		}
+	}
+	if u.Channel == "" {
+		u.Channel = domain.ChannelEmail
+	}
+	fmt.Printf("channel: %s\nwebhook-url: %s\n", u.Channel, u.WebhookURL)
+	return nil
+}
+
+// profileFlags are the flags of `users profile`.
+type profileFlags struct {
+	email, name, timezone, currency, newEmail string
+	optIn                                     bool
+
+	// set holds the names of the flags given; only those are updated.
+	set map[string]bool
+}
+
+func (f *profileFlags) define(fs *flag.FlagSet) {
+	fs.StringVar(&f.email, "email", "", "User email")
+	fs.StringVar(&f.name, "name", "", "Display name")
+	fs.StringVar(&f.timezone, "timezone", "", "IANA timezone (America/Mexico_City)")
+	fs.StringVar(&f.currency, "currency", "", "Preferred currency, ISO 4217 (MXN, USD)")
+	fs.BoolVar(&f.optIn, "opt-in", true, "Whether the user receives reports")
+	fs.StringVar(&f.newEmail, "new-email", "", "Change the user's email")
+}
+
+func (f *profileFlags) check(fs *flag.FlagSet) error {
+	if f.email == "" {
+		return domain.Errorf(domain.ErrValidation, "email flag is required")
+	}
+	f.set = map[string]bool{}
+	fs.Visit(func(fl *flag.Flag) { f.set[fl.Name] = true })
+	if f.set["new-email"] && strings.TrimSpace(f.newEmail) == "" {
+		return domain.Errorf(domain.ErrValidation, "new-email flag is empty")
+	}
+	var err error
+	if f.timezone, err = domain.ParseTimezone(f.timezone); err != nil {
+		return err
+	}
+	f.currency, err = domain.ParseCurrency(f.currency)
+	return err
+}
+
+func (f *profileFlags) emails() []*string { return []*string{&f.email, &f.newEmail} }
+
+// runProfile shows or updates a user's profile; --new-email changes the
+// email the user is known by, keeping their id and data.
+func runProfile(ctx context.Context, e *env, f *profileFlags) error {
+	update := f.set["name"] || f.set["timezone"] || f.set["currency"] || f.set["opt-in"]
+	u, err := userFor(ctx, e.db, f.email, update || f.set["new-email"])
+	if err != nil {
+		return err
+	}
+	users := repositories.NewUserRepository(e.db)
+	if f.set["name"] {
+		u.Name = strings.TrimSpace(f.name)
+	}
+	if f.set["timezone"] {
+		u.Timezone = f.timezone
+	}
+	if f.set["currency"] {
+		u.Currency = f.currency
+	}
+	if f.set["opt-in"] {
+		u.ReportOptIn = f.optIn
+	}
+	if update {
+		if err := users.Update(ctx, u); err != nil {
+			return err
+		}
+	}
+	if f.set["new-email"] && f.newEmail != u.Email {
+		if err := users.ChangeEmail(ctx, u.ID, f.newEmail); err != nil {
+			return err
+		}
+		u.Email = f.newEmail
+	}
+
+	fmt.Printf("id: %s\nemail: %s\nname: %s\nlocale: %s\ntimezone: %s\ncurrency: %s\nreport-opt-in: %t\n",
+		u.ID, u.Email, u.Name, domain.ParseLocale(string(u.Locale)), u.Timezone, u.Currency, u.ReportOptIn)
+	return nil
+}
+
+// accountsFlags are the flags of `users accounts`.
+type accountsFlags struct {
+	email, add, accountType, currency string
+
+	typ domain.AccountType
+}
+
+func (f *accountsFlags) define(fs *flag.FlagSet) {
+	fs.StringVar(&f.email, "email", "", "User email")
+	fs.StringVar(&f.add, "add", "", "Name of an account to create")
+	fs.StringVar(&f.accountType, "type", "debit", "Type of the new account (debit, credit)")
+	fs.StringVar(&f.currency, "currency", "", "Currency of the new account, ISO 4217 (defaults to the user's currency)")
+}
+
+func (f *accountsFlags) check(*flag.FlagSet) error {
+	if f.email == "" {
+		return domain.Errorf(domain.ErrValidation, "email flag is required")
+	}
+	f.add = strings.TrimSpace(f.add)
+	var err error
+	if f.typ, err = domain.ParseAccountType(f.accountType); err != nil {
+		return err
+	}
+	f.currency, err = domain.ParseCurrency(f.currency)
+	return err
+}
+
+func (f *accountsFlags) emails() []*string { return []*string{&f.email} }
+
+// runAccounts lists a user's accounts or, with --add, opens a new one that
+// CSVs can then be imported into with --account. Only --add creates the user
+// and their main account.
+func runAccounts(ctx context.Context, e *env, f *accountsFlags) error {
+	u, err := userFor(ctx, e.db, f.email, f.add != "")
+	if err != nil {
+		return err
+	}
+	accounts := repositories.NewAccountRepository(e.db)
+	if f.add != "" {
+		if _, err := accounts.Default(ctx, u.ID, u.Currency); err != nil {
+			return err
+		}
+		currency := f.currency
+		if currency == "" {
+			currency = u.Currency
+		}
+		if err := accounts.Create(ctx, &domain.Account{UserID: u.ID, Name: f.add, Type: f.typ, Currency: currency}); err != nil {
+			return err
+		}
+	}
+
+	list, err := accounts.List(ctx, u.ID)
+	if err != nil {
+		return err
+	}
+	for _, a := range list {
+		fmt.Printf("%s\t%s\t%s\n", a.Name, a.Type, a.Currency)
+	}
+	return nil
+}
+
+// serveFlags are the flags of `serve`.
+type serveFlags struct {
+	addr string
+}
+
+func (f *serveFlags) define(fs *flag.FlagSet) {
+	fs.StringVar(&f.addr, "addr", "", "Listen address (defaults to HTTP_ADDR)")
+}
+
+func (f *serveFlags) check(*flag.FlagSet) error { return nil }
+
+// runServe answers the unsubscribe links of report emails on /unsubscribe
+// until interrupted.
+func runServe(ctx context.Context, e *env, f *serveFlags) error {
+	cfg, logger := e.cfg, e.logger
+	if cfg.UnsubscribeSecret == "" {
+		return domain.Errorf(domain.ErrValidation, "UNSUBSCRIBE_SECRET is required to verify unsubscribe links")
+	}
+	addr := f.addr
+	if addr == "" {
+		addr = cfg.HTTPAddr
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/unsubscribe", unsubscribe.NewHandler(
+		unsubscribe.NewLinks(cfg.UnsubscribeSecret, cfg.UnsubscribeBaseURL),
+		services.NewReportPreferences(repositories.NewUserRepository(e.db), logger),
+		logger,
+	))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe() }()
+	logger.InfoContext(ctx, "serving", "addr", addr)
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
+
+// normalizeEmailsFlags are the flags of `users normalize-emails`.
+type normalizeEmailsFlags struct {
+	dryRun bool
+}
+
+func (f *normalizeEmailsFlags) define(fs *flag.FlagSet) {
+	fs.BoolVar(&f.dryRun, "dry-run", false, "List the merges without changing anything")
+}
+
+func (f *normalizeEmailsFlags) check(*flag.FlagSet) error { return nil }
+
+// runNormalizeEmails merges users stored under non-canonical emails (from
+// before emails were validated) into their canonical form.
+func runNormalizeEmails(ctx context.Context, e *env, f *normalizeEmailsFlags) error {
+	merges, invalid, err := services.NewEmailNormalizer(repositories.NewUserRepository(e.db), e.cfg.EmailCaseInsensitiveLocal, e.logger).Run(ctx, f.dryRun)
+	for _, m := range merges {
+		fmt.Printf("%q -> %q\n", m.From, m.Into)
+	}
+	for _, s := range invalid {
+		fmt.Printf("%q is not a valid email; left as is\n", s)
+	}
+	if err != nil {
+		return err
+	}
+	verb := "merged"
+	if f.dryRun {
+		verb = "to merge"
+	}
+	fmt.Printf("Users %s: %d, invalid: %d\n", verb, len(merges), len(invalid))
+	return nil
+}
+
+// validateTemplateFlags are the flags of `validate template`.
+type validateTemplateFlags struct {
+	templateRef, locale string
+
+	loc domain.Locale
+}
+
+func (f *validateTemplateFlags) define(fs *flag.FlagSet) {
+	fs.StringVar(&f.templateRef, "template", "", "Template name, local path or s3://bucket/key; empty validates the default template")
+	fs.StringVar(&f.locale, "locale", "", "Locale used for the sample render (en-US, es-MX)")
+}
+
+func (f *validateTemplateFlags) check(*flag.FlagSet) error {
+	if f.locale == "" {
+		f.loc = domain.DefaultLocale
+		return nil
+	}
+	var err error
+	f.loc, err = domain.LookupLocale(f.locale)
+	return err
+}
+
+// runValidateTemplate parses a template and dry-runs it against a sample
+// report, so broken templates are caught before send time.
+func runValidateTemplate(ctx context.Context, _ *env, f *validateTemplateFlags) error {
+	// Validating a template does not need the DB settings, so a partial config is fine.
+	cfg, _ := readConfig()
+
+	tpl, err := newTemplateProvider(cfg).Resolve(ctx, f.templateRef)
+	if err != nil {
+		return err
+	}
+	problems := tpl.Validate(f.loc)
+	if len(problems) == 0 {
+		fmt.Println("template OK")
+		return nil
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	return domain.Errorf(domain.ErrTemplateInvalid, "%d problem(s) found", len(problems))
+}
+
+// runMigrate brings the database schema up to date and exits; connecting
+// migrates.
+func runMigrate(context.Context, *env, *noFlags) error {
+	fmt.Println("schema up to date")
+	return nil
+}
+
+// validateCSVFlags are the flags of `validate csv`.
+type validateCSVFlags struct {
+	source string
+}
+
+func (f *validateCSVFlags) define(fs *flag.FlagSet) {
+	fs.StringVar(&f.source, "src", "", "CSV Route (local or s3://bucket/key)")
+}
+
+func (f *validateCSVFlags) check(*flag.FlagSet) error {
+	if f.source == "" {
+		return domain.Errorf(domain.ErrValidation, "src flag is required")
+	}
+	return nil
+}
+
+// runValidateCSV parses a CSV source without storing it, so a bad file is
+// caught before it is ingested.
+func runValidateCSV(ctx context.Context, _ *env, f *validateCSVFlags) error {
+	// Reading a CSV does not need the DB settings, so a partial config is fine.
+	cfg, _ := readConfig()
+
+	rc, err := reader.NewAutoReader(newS3Reader(cfg)).Open(ctx, f.source)
+	if err != nil {
+		return domain.Wrap("open source", nil, err)
+	}
+	defer rc.Close()
+	txs, err := parser.ParseTransactionsCSV(ctx, rc, "", time.Now())
+	if err != nil {
+		return domain.Wrap("parse csv", domain.ErrValidation, err)
+	}
+	fmt.Printf("csv OK: %d transactions\n", len(txs))
+	return nil
+}
+
+// parseMonth parses an optional --month flag; empty is the zero period.
+func parseMonth(month string) (domain.Period, error) {
+	if month == "" {
+		return domain.Period{}, nil
+	}
+	return domain.ParseMonth(month, time.Local)
+}
+
+// loadConfig reads the config; a missing or malformed setting is an input error.
+func loadConfig() (*config.Config, error) {
+	cfg, err := readConfig()
+	if err != nil {
+		return nil, domain.Wrap("config", domain.ErrValidation, err)
+	}
+	return cfg, nil
+}
+
+// readConfig layers the global flags over the environment, which is layered
+// over the --config file (or CONFIG_FILE).
+func readConfig() (*config.Config, error) {
+	path := globals.config
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	cfg, err := config.LoadFile(path)
+	if globals.logLevel != "" {
+		cfg.LogLevel = globals.logLevel
+	}
+	if globals.logFormat != "" {
+		cfg.LogFormat = globals.logFormat
+	}
+	return cfg, err
+}
+
+// newLogger sets up the process logger (stderr, so report output on stdout
+// stays clean) and derives from ctx a context carrying this run's correlation id.
+func newLogger(ctx context.Context, cfg *config.Config) (*slog.Logger, context.Context) {
+	logger := logging.New(os.Stderr, cfg.LogLevel, cfg.LogFormat)
+	slog.SetDefault(logger)
+	return logger, logging.WithCorrelationID(ctx, logging.NewCorrelationID())
+}
+
+// newTelemetry installs the exporters enabled in cfg (OTLP, Prometheus) and
+// serves /metrics on METRICS_ADDR while the command runs. The returned func
+// flushes pending telemetry and stops the server.
+func newTelemetry(ctx context.Context, cfg *config.Config, logger *slog.Logger) (func(), error) {
+	tel, err := telemetry.Setup(ctx, telemetry.Options{
+		ServiceName: cfg.ServiceName,
+		OTLP:        cfg.OTLPEndpoint != "",
+		Prometheus:  cfg.MetricsAddr != "",
+	})
+	if err != nil {
+		return nil, domain.Wrap("telemetry", nil, err)
+	}
+
+	var srv *http.Server
+	if cfg.MetricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", tel.Handler())
+		srv = &http.Server{Addr: cfg.MetricsAddr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("metrics server stopped", "addr", cfg.MetricsAddr, "error", err)
+			}
+		}()
+	}
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if srv != nil {
+			_ = srv.Shutdown(ctx)
+		}
+		if err := tel.Shutdown(ctx); err != nil {
+			logger.Warn("telemetry shutdown failed", "error", err)
+		}
+	}, nil
+}
+
+func newReportService(cfg *config.Config, gdb *gorm.DB, rdr ports.Reader, logger *slog.Logger) ports.TransactionReportService {
+	templates := newTemplateProvider(cfg)
+	render := func(ctx context.Context, sum domain.MonthlySummary, txs []domain.Transaction, u, t string, l domain.Locale) (string, []domain.EmailAsset, error) {
+		tpl, err := templates.Resolve(ctx, t)
+		if err != nil {
+			return "", nil, err
+		}
+		out, err := tpl.Render(newModel(cfg, sum, txs, u, l))
+		return out.HTML, out.Inline, err
+	}
+	renderJSON := func(_ context.Context, sum domain.MonthlySummary, txs []domain.Transaction, u string, l domain.Locale) ([]byte, error) {
+		out, err := templating.RenderText(newModel(cfg, sum, txs, u, l), templating.FormatJSON)
+		return []byte(out), err
+	}
+
+	return services.NewTransactionReportService(
 		rdr,
-		users,
-		transactions,
-		mailer,
+		repositories.NewUserRepository(gdb),
+		repositories.NewAccountRepository(gdb),
+		repositories.NewTransactionRepository(gdb),
+		repositories.NewOutboxRepository(gdb),
+		repositories.NewReportRunRepository(gdb),
+		db.NewTransactor(gdb),
 		render,
+		renderJSON,
 		parser.ParseTransactionsCSV,
+		services.StepTimeouts{
+			Read:   time.Duration(cfg.SourceTimeoutSecs) * time.Second,
+			Parse:  time.Duration(cfg.ParseTimeoutSecs) * time.Second,
+			DB:     time.Duration(cfg.DBStepTimeoutSecs) * time.Second,
+			Render: time.Duration(cfg.RenderTimeoutSecs) * time.Second,
+		},
+		logger,
+	)
+}
+
+func newS3Reader(cfg *config.Config) func() (ports.Reader, error) {
+	return func() (ports.Reader, error) {
+		return reader.NewS3Reader(cfg.S3Region, cfg.S3Endpoint, cfg.S3AccessKey, cfg.S3SecretKey, cfg.S3ForcePathStyle)
+	}
+}
+
+func newModel(cfg *config.Config, sum domain.MonthlySummary, txs []domain.Transaction, userEmail string, locale domain.Locale) templating.Model {
+	model := templating.BuildModel(sum, userEmail, time.Now(), locale)
+	model.AddTransactions(txs, templating.DetailOptions{MaxRows: cfg.ReportDetailMaxRows, TopN: cfg.ReportTopN})
+	model.SetChartMode(cfg.ReportCharts)
+	return model
+}
+
+func newTemplateProvider(cfg *config.Config) *templating.Provider {
+	return templating.NewProvider(templating.ProviderOptions{
+		Dir:      cfg.ReportTemplateDir,
+		Default:  cfg.ReportTemplatePath,
+		CacheTTL: time.Duration(cfg.ReportTemplateCacheSecs) * time.Second,
+		S3:       newS3Reader(cfg),
+	})
+}
+
+// parseEmail canonicalises a user email given on the command line or in a manifest.
+func parseEmail(cfg *config.Config, raw string) (string, error) {
+	return domain.ParseEmail(raw, cfg.EmailCaseInsensitiveLocal)
+}
+
+// parseAddresses validates delivery addresses. They are not user keys, so
+// their local part is kept as given.
+func parseAddresses(raw []string) ([]string, error) {
+	var out []string
+	for _, r := range raw {
+		a, err := domain.ParseEmail(r, false)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if p := strings.TrimSpace(part); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func newDispatcher(cfg *config.Config, gdb *gorm.DB, logger *slog.Logger) ports.OutboxDispatcher {
+	return services.NewOutboxDispatcher(
+		repositories.NewOutboxRepository(gdb),
+		map[domain.Channel]ports.Notifier{
+			domain.ChannelEmail: email.NewNotifier(email.NewSMTPSender(cfg), unsubscribeURL(cfg)),
+			domain.ChannelWebhook: webhook.NewNotifier(webhook.Options{
+				Secret:     cfg.WebhookSecret,
+				Timeout:    time.Duration(cfg.WebhookTimeoutSecs) * time.Second,
+				MaxRetries: cfg.WebhookMaxRetries,
+			}),
+		},
+		services.OutboxDispatcherOptions{
+			BatchSize:   cfg.OutboxBatchSize,
+			MaxAttempts: cfg.OutboxMaxAttempts,
+			BackoffBase: time.Duration(cfg.OutboxBackoffBaseSecs) * time.Second,
+			BackoffMax:  time.Duration(cfg.OutboxBackoffMaxSecs) * time.Second,
+		},
+		logger,
 	)
+}
 
-	if err := svc.Process(context.Background(), emailTo, source, template); err != nil {
-		panic(err)
+// unsubscribeURL returns the builder of signed unsubscribe links, or nil
+// (no List-Unsubscribe headers) when they are not configured.
+func unsubscribeURL(cfg *config.Config) func(userID string) string {
+	if cfg.UnsubscribeSecret == "" || cfg.UnsubscribeBaseURL == "" {
+		return nil
 	}
-}
\ No newline at end of file
+	return unsubscribe.NewLinks(cfg.UnsubscribeSecret, cfg.UnsubscribeBaseURL).URL
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/Vasenti/stori_challenge/internal/application/ports"
+	"github.com/Vasenti/stori_challenge/internal/domain"
+	"github.com/Vasenti/stori_challenge/internal/intrastructure/db/repositories"
+)
+
+// summaryOutput is the JSON shape of `summary`; names match the report JSON.
+type summaryOutput struct {
+	UserEmail    string           `json:"user_email"`
+	Period       string           `json:"period,omitempty"`
+	BalanceTotal float64          `json:"balance_total"`
+	AvgDebit     float64          `json:"avg_debit"`
+	AvgCredit    float64          `json:"avg_credit"`
+	ByMonth      []summaryMonth   `json:"by_month"`
+	Accounts     []summaryAccount `json:"accounts"`
+}
+
+type summaryMonth struct {
+	Year      int        `json:"year"`
+	Month     time.Month `json:"month"`
+	MonthName string     `json:"month_name"`
+	Count     int        `json:"count"`
+	Credits   float64    `json:"credits"`
+	Debits    float64    `json:"debits"`
+}
+
+type summaryAccount struct {
+	Name         string  `json:"name"`
+	Type         string  `json:"type"`
+	Currency     string  `json:"currency,omitempty"`
+	BalanceTotal float64 `json:"balance_total"`
+	AvgDebit     float64 `json:"avg_debit"`
+	AvgCredit    float64 `json:"avg_credit"`
+	Count        int     `json:"transactions_count"`
+}
+
+// transactionOutput is one row of `transactions list --format=json`.
+type transactionOutput struct {
+	ID         uint      `json:"id"`
+	Account    string    `json:"account"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Amount     float64   `json:"amount"`
+}
+
+// summaryFlags are the flags of `summary`.
+type summaryFlags struct {
+	email, month, format string
+
+	period domain.Period
+}
+
+func (f *summaryFlags) define(fs *flag.FlagSet) {
+	fs.StringVar(&f.email, "email", "", "User email")
+	fs.StringVar(&f.month, "month", "", "Month to summarise, YYYY-MM (defaults to all stored transactions)")
+	fs.StringVar(&f.format, "format", "table", "Output format: table or json")
+}
+
+func (f *summaryFlags) check(*flag.FlagSet) error {
+	if f.email == "" {
+		return domain.Errorf(domain.ErrValidation, "email flag is required")
+	}
+	if err := checkFormat(f.format); err != nil {
+		return err
+	}
+	var err error
+	f.period, err = parseMonth(f.month)
+	return err
+}
+
+func (f *summaryFlags) emails() []*string { return []*string{&f.email} }
+
+// runSummary prints a user's stored summary, as the report would show it,
+// without sending anything.
+func runSummary(ctx context.Context, e *env, f *summaryFlags) error {
+	gdb, period := e.db, f.period
+	u, err := findUser(ctx, gdb, f.email)
+	if err != nil {
+		return err
+	}
+	sum, err := repositories.NewTransactionRepository(gdb).GetMonthlySummary(ctx, u.ID, period)
+	if err != nil {
+		return err
+	}
+
+	out := summaryOutput{
+		UserEmail:    u.Email,
+		Period:       period.Key(),
+		BalanceTotal: sum.BalanceTotal,
+		AvgDebit:     sum.AvgDebit,
+		AvgCredit:    sum.AvgCredit,
+		ByMonth:      []summaryMonth{},
+		Accounts:     []summaryAccount{},
+	}
+	for m, n := range sum.TransactionsByMonth {
+		out.ByMonth = append(out.ByMonth, summaryMonth{
+			Year:      m.Year,
+			Month:     m.Month,
+			MonthName: m.Month.String(),
+			Count:     n,
+			Credits:   sum.CreditsByMonth[m],
+			Debits:    sum.DebitsByMonth[m],
+		})
+	}
+	sort.Slice(out.ByMonth, func(i, j int) bool {
+		a, b := out.ByMonth[i], out.ByMonth[j]
+		return a.Year < b.Year || a.Year == b.Year && a.Month < b.Month
+	})
+	for _, a := range sum.Accounts {
+		out.Accounts = append(out.Accounts, summaryAccount{
+			Name:         a.Account.Name,
+			Type:         string(a.Account.Type),
+			Currency:     a.Account.Currency,
+			BalanceTotal: a.BalanceTotal,
+			AvgDebit:     a.AvgDebit,
+			AvgCredit:    a.AvgCredit,
+			Count:        a.Transactions,
+		})
+	}
+
+	if f.format == "json" {
+		return printJSON(out)
+	}
+	label := out.Period
+	if label == "" {
+		label = "all time"
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "User\t%s\nPeriod\t%s\nBalance\t%.2f\nAvg credit\t%.2f\nAvg debit\t%.2f\n",
+		out.UserEmail, label, out.BalanceTotal, out.AvgCredit, out.AvgDebit)
+	fmt.Fprintln(w, "\nMONTH\tTRANSACTIONS\tCREDITS\tDEBITS")
+	for _, m := range out.ByMonth {
+		fmt.Fprintf(w, "%s %d\t%d\t%.2f\t%.2f\n", m.MonthName, m.Year, m.Count, m.Credits, m.Debits)
+	}
+	fmt.Fprintln(w, "\nACCOUNT\tTYPE\tCURRENCY\tTRANSACTIONS\tBALANCE\tAVG CREDIT\tAVG DEBIT")
+	for _, a := range out.Accounts {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%.2f\t%.2f\t%.2f\n", a.Name, a.Type, a.Currency, a.Count, a.BalanceTotal, a.AvgCredit, a.AvgDebit)
+	}
+	return w.Flush()
+}
+
+// transactionsListFlags are the flags of `transactions list`.
+type transactionsListFlags struct {
+	email, account, from, to, minAmount, maxAmount, sign, format string
+	limit, page                                                  int
+
+	filter ports.TransactionFilter
+}
+
+func (f *transactionsListFlags) define(fs *flag.FlagSet) {
+	fs.StringVar(&f.email, "email", "", "User email")
+	fs.StringVar(&f.account, "account", "", "Only this account (defaults to all of the user's accounts)")
+	fs.StringVar(&f.from, "from", "", "First day, YYYY-MM-DD")
+	fs.StringVar(&f.to, "to", "", "Last day, YYYY-MM-DD (inclusive)")
+	fs.StringVar(&f.minAmount, "min", "", "Minimum amount, signed (debits are negative)")
+	fs.StringVar(&f.maxAmount, "max", "", "Maximum amount, signed (debits are negative)")
+	fs.StringVar(&f.sign, "sign", "", "Only credits or debits (credit, debit)")
+	fs.IntVar(&f.limit, "limit", 50, "Transactions per page (0 lists them all)")
+	fs.IntVar(&f.page, "page", 1, "Page to print, from 1")
+	fs.StringVar(&f.format, "format", "table", "Output format: table or json")
+}
+
+func (f *transactionsListFlags) check(*flag.FlagSet) error {
+	if f.email == "" {
+		return domain.Errorf(domain.ErrValidation, "email flag is required")
+	}
+	if err := checkFormat(f.format); err != nil {
+		return err
+	}
+	if f.limit < 0 || f.page < 1 {
+		return domain.Errorf(domain.ErrValidation, "limit must be 0 or more and page 1 or more")
+	}
+	flt := &f.filter
+	flt.Limit, flt.Offset = f.limit, (f.page-1)*f.limit
+	var err error
+	if flt.From, err = parseDay("from", f.from); err != nil {
+		return err
+	}
+	if flt.To, err = parseDay("to", f.to); err != nil {
+		return err
+	}
+	if !flt.To.IsZero() {
+		flt.To = flt.To.AddDate(0, 0, 1)
+	}
+	if flt.MinAmount, err = parseAmount("min", f.minAmount); err != nil {
+		return err
+	}
+	if flt.MaxAmount, err = parseAmount("max", f.maxAmount); err != nil {
+		return err
+	}
+	flt.Sign, err = domain.ParseSign(f.sign)
+	return err
+}
+
+func (f *transactionsListFlags) emails() []*string { return []*string{&f.email} }
+
+// runTransactionsList prints a page of a user's stored transactions,
+// newest first.
+func runTransactionsList(ctx context.Context, e *env, f *transactionsListFlags) error {
+	gdb, flt, limit, page := e.db, f.filter, f.limit, f.page
+	u, err := findUser(ctx, gdb, f.email)
+	if err != nil {
+		return err
+	}
+	accounts := repositories.NewAccountRepository(gdb)
+	if f.account != "" {
+		a, err := accounts.GetByName(ctx, u.ID, f.account)
+		if err != nil {
+			return err
+		}
+		flt.AccountID = a.ID
+	}
+	list, err := accounts.List(ctx, u.ID)
+	if err != nil {
+		return err
+	}
+	names := make(map[string]string, len(list))
+	for _, a := range list {
+		names[a.ID] = a.Name
+	}
+
+	txs, total, err := repositories.NewTransactionRepository(gdb).Find(ctx, u.ID, flt)
+	if err != nil {
+		return err
+	}
+
+	rows := make([]transactionOutput, 0, len(txs))
+	for _, t := range txs {
+		rows = append(rows, transactionOutput{ID: t.ID, Account: names[t.AccountID], OccurredAt: t.OccurredAt, Amount: t.Amount})
+	}
+	pages := 1
+	if limit > 0 {
+		pages = max(1, int((total+int64(limit)-1)/int64(limit)))
+	}
+	if f.format == "json" {
+		return printJSON(struct {
+			Transactions []transactionOutput `json:"transactions"`
+			Total        int64               `json:"total"`
+			Page         int                 `json:"page"`
+			Pages        int                 `json:"pages"`
+		}{rows, total, page, pages})
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DATE\tACCOUNT\tID\tAMOUNT")
+	for _, r := range rows {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%.2f\n", r.OccurredAt.Format(time.DateOnly), r.Account, r.ID, r.Amount)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("page %d of %d (%d transactions)\n", page, pages, total)
+	return nil
+}
+
+// findUser returns the user with email without creating it: querying an
+// unknown user is an input error.
+func findUser(ctx context.Context, gdb *gorm.DB, email string) (domain.User, error) {
+	u, err := repositories.NewUserRepository(gdb).GetByEmail(ctx, email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return domain.User{}, domain.Errorf(domain.ErrValidation, "unknown user %q", email)
+	}
+	return u, err
+}
+
+// userFor returns the user with email for a users command. Only a command
+// that stores a setting creates the user; showing the settings of an
+// unknown user is an input error, as with findUser.
+func userFor(ctx context.Context, gdb *gorm.DB, email string, write bool) (domain.User, error) {
+	if write {
+		return repositories.NewUserRepository(gdb).Ensure(ctx, email)
+	}
+	return findUser(ctx, gdb, email)
+}
+
+func checkFormat(format string) error {
+	if format != "table" && format != "json" {
+		return domain.Errorf(domain.ErrValidation, "unknown format %q (table, json)", format)
+	}
+	return nil
+}
+
+// parseDay parses a YYYY-MM-DD flag in the local timezone, where CSV dates
+// are parsed too; empty is the zero time.
+func parseDay(name, s string) (time.Time, error) {
+	if s = strings.TrimSpace(s); s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.ParseInLocation(time.DateOnly, s, time.Local)
+	if err != nil {
+		return time.Time{}, domain.Errorf(domain.ErrValidation, "invalid %s date %q (want YYYY-MM-DD)", name, s)
+	}
+	return t, nil
+}
+
+// parseAmount parses an amount flag; empty is nil (no bound).
+func parseAmount(name, s string) (*float64, error) {
+	if s = strings.TrimSpace(s); s == "" {
+		return nil, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, domain.Errorf(domain.ErrValidation, "invalid %s amount %q", name, s)
+	}
+	return &v, nil
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
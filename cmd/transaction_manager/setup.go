@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"gorm.io/gorm"
+
+	"github.com/Vasenti/stori_challenge/internal/config"
+	"github.com/Vasenti/stori_challenge/internal/intrastructure/db"
+)
+
+// setup is what a command needs prepared before it runs.
+type setup int
+
+const (
+	// noSetup commands read what config they need themselves.
+	noSetup setup = iota
+	// withDB loads the config, sets up the logger and connects to the DB.
+	withDB
+	// withTelemetry is withDB plus the telemetry exporters.
+	withTelemetry
+)
+
+// env is what setup prepared for a command.
+type env struct {
+	cfg    *config.Config
+	logger *slog.Logger
+	db     *gorm.DB
+}
+
+// userEmails is implemented by flag values that name users by email; setup
+// canonicalises the ones given before the command runs.
+type userEmails interface {
+	emails() []*string
+}
+
+// prepare runs s for the command whose flags are v. It returns ctx carrying
+// the run's correlation id and a func that releases what was set up.
+func (s setup) prepare(ctx context.Context, v flagValues) (context.Context, *env, func(), error) {
+	if s == noSetup {
+		return ctx, &env{}, func() {}, nil
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if u, ok := v.(userEmails); ok {
+		for _, email := range u.emails() {
+			if *email == "" {
+				continue
+			}
+			if *email, err = parseEmail(cfg, *email); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+	}
+	logger, ctx := newLogger(ctx, cfg)
+
+	done := func() {}
+	if s == withTelemetry {
+		if done, err = newTelemetry(ctx, cfg, logger); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	gdb, err := db.NewGorm(cfg, logger)
+	if err != nil {
+		done()
+		return nil, nil, nil, err
+	}
+	return ctx, &env{cfg: cfg, logger: logger, db: gdb}, done, nil
+}
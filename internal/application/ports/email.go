@@ -1,5 +1,25 @@
 package ports
 
+import (
+	"context"
+
+	"github.com/Vasenti/stori_challenge/internal/domain"
+)
+
+type EmailMessage struct {
+	// To[0] is the primary recipient: the message fails if it is refused,
+	// while other refused recipients are skipped.
+	To       []string
+	Cc       []string
+	Bcc      []string
+	ReplyTo  string
+	Headers  map[string]string
+	Subject  string
+	HTMLBody string
+	// Inline files are referenced from HTMLBody as cid: URLs.
+	Inline []domain.EmailAsset
+}
+
 type EmailSender interface {
-	Send(to string, subject string, htmlBody string) error
-}
\ No newline at end of file
+	Send(ctx context.Context, msg EmailMessage) error
+}
@@ -0,0 +1,19 @@
+package ports
+
+import "context"
+
+// Notification is a queued report handed to the Notifier of its channel.
+// Email is used by the email channel; WebhookURL and Payload by the webhook one.
+type Notification struct {
+	// ID identifies the report across retries so receivers can deduplicate.
+	ID         string
+	UserID     string
+	Recipient  string
+	Email      EmailMessage
+	WebhookURL string
+	Payload    []byte
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
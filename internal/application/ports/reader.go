@@ -1,7 +1,11 @@
 package ports
 
-import "io"
+import (
+	"context"
+	"io"
+)
 
+// Reader opens a source by path. ctx bounds the open, including any download.
 type Reader interface {
-	Open(path string) (io.ReadCloser, error)
-}
\ No newline at end of file
+	Open(ctx context.Context, path string) (io.ReadCloser, error)
+}
@@ -2,14 +2,103 @@ package ports
 
 import (
 	"context"
+	"time"
+
 	"github.com/Vasenti/stori_challenge/internal/domain"
 )
 
 type UserRepository interface {
-	Ensure(ctx context.Context, email string) error
+	// Ensure returns the user with email, creating it (opted in to reports)
+	// if there is none.
+	Ensure(ctx context.Context, email string) (domain.User, error)
+	Get(ctx context.Context, id string) (domain.User, error)
+	GetByEmail(ctx context.Context, email string) (domain.User, error)
+	// FindByEmail is GetByEmail reporting whether the user exists instead of
+	// failing when it does not.
+	FindByEmail(ctx context.Context, email string) (domain.User, bool, error)
+	// Update stores u's profile and delivery settings; its ID and Email are
+	// not changed.
+	Update(ctx context.Context, u domain.User) error
+	// ChangeEmail moves user id to email in one statement; the user's
+	// transactions and reports follow since they reference the id. It fails
+	// with domain.ErrValidation if another user has email.
+	ChangeEmail(ctx context.Context, id, email string) error
+	// SetReportOptIn stores whether user id receives reports; unknown ids
+	// are ignored.
+	SetReportOptIn(ctx context.Context, id string, optIn bool) error
+	// ListOptedIn returns the users who receive reports, in email order.
+	ListOptedIn(ctx context.Context) ([]domain.User, error)
+	// Emails lists every stored user email, as stored.
+	Emails(ctx context.Context) ([]string, error)
+	// Merge moves from's accounts, transactions, queued reports and report
+	// runs to into and deletes from. into is created with from's settings if
+	// it does not exist; otherwise its own settings are kept, except that
+	// into is opted out of reports if either user was.
+	Merge(ctx context.Context, from, into string) error
+}
+
+type AccountRepository interface {
+	// Default returns the user's domain.DefaultAccountName account, creating
+	// it (a debit account in currency) if there is none.
+	Default(ctx context.Context, userID, currency string) (domain.Account, error)
+	// GetByName fails with domain.ErrValidation if the user has no account
+	// called name.
+	GetByName(ctx context.Context, userID, name string) (domain.Account, error)
+	// Create stores a, assigning its ID. It fails with domain.ErrValidation
+	// if the user already has an account with a.Name.
+	Create(ctx context.Context, a *domain.Account) error
+	// List returns the user's accounts in name order.
+	List(ctx context.Context, userID string) ([]domain.Account, error)
+}
+
+// ReportRunRepository records which monthly reports were already queued.
+type ReportRunRepository interface {
+	// Claim records the report of userID for period and reports whether
+	// this call recorded it; false means it was queued before.
+	Claim(ctx context.Context, userID, period string) (bool, error)
 }
 
 type TransactionRepository interface {
-	BulkUpsert(ctx context.Context, txs []domain.Transaction) error
-	GetMonthlySummary(ctx context.Context, userEmail string) (domain.MonthlySummary, error)
-}
\ No newline at end of file
+	// BulkUpsert stores txs, skipping duplicates, and returns how many rows were new.
+	BulkUpsert(ctx context.Context, txs []domain.Transaction) (int64, error)
+	// GetMonthlySummary consolidates every account of the user over period
+	// (all time when zero) and breaks the totals down per account. Accounts
+	// in different currencies are not consolidated: that is ErrValidation.
+	GetMonthlySummary(ctx context.Context, userID string, period domain.Period) (domain.MonthlySummary, error)
+	// List returns the transactions of every account of the user in period
+	// (all time when zero).
+	List(ctx context.Context, userID string, period domain.Period) ([]domain.Transaction, error)
+	// Find returns the page of the user's transactions that match f, newest
+	// first, and how many match in all.
+	Find(ctx context.Context, userID string, f TransactionFilter) ([]domain.Transaction, int64, error)
+}
+
+// TransactionFilter narrows TransactionRepository.Find; zero fields do not filter.
+type TransactionFilter struct {
+	AccountID string
+	// From and To bound the date to [From, To); either can be zero.
+	From time.Time
+	To   time.Time
+	// MinAmount and MaxAmount bound the signed amount, inclusive.
+	MinAmount *float64
+	MaxAmount *float64
+	Sign      domain.Sign
+	// Limit and Offset select the page; Limit 0 returns every match.
+	Limit  int
+	Offset int
+}
+
+type OutboxRepository interface {
+	Enqueue(ctx context.Context, msg *domain.OutboxMessage) error
+	// ClaimDue returns up to limit pending messages due at now and pushes their
+	// next attempt forward by lease so concurrent dispatchers skip them.
+	ClaimDue(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]domain.OutboxMessage, error)
+	MarkSent(ctx context.Context, id uint, at time.Time) error
+	MarkFailed(ctx context.Context, id uint, attempts int, nextAttemptAt time.Time, lastErr string, dead bool) error
+}
+
+// Transactor runs fn inside a single database transaction; repositories called
+// with the ctx passed to fn join that transaction.
+type Transactor interface {
+	WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
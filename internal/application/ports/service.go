@@ -1,7 +1,135 @@
 package ports
 
-import "context"
+import (
+	"context"
+	"time"
+
+	"github.com/Vasenti/stori_challenge/internal/domain"
+)
 
 type TransactionReportService interface {
-	Process(ctx context.Context, userEmail string, csvSourcePath string, templateHtml string) error
-}
\ No newline at end of file
+	// Process ingests the CSV and queues the all-time report on the user's
+	// channel (Ingest then Report, in one DB transaction), unless the user
+	// opted out of reports.
+	Process(ctx context.Context, userEmail string, csvSourcePath string, opts ReportOptions) error
+	// Preview parses the CSV and returns the report data of its transactions
+	// alone, for the non-email output formats. It stores nothing, not even
+	// the user. Only opts.Account and opts.Locale apply.
+	Preview(ctx context.Context, userEmail string, csvSourcePath string, opts ReportOptions) (ReportData, error)
+	// Ingest imports the CSV into the user's account (the default one when
+	// empty) without queueing a report.
+	Ingest(ctx context.Context, userEmail string, csvSourcePath string, account string) (ImportResult, error)
+	// Report queues the report of period (all time when zero) from stored
+	// transactions; with opts.Once at most once per user and period, with
+	// opts.RunKey at most once per user and key.
+	Report(ctx context.Context, userEmail string, period domain.Period, opts ReportOptions) (ReportStatus, error)
+}
+
+// ImportResult describes one ingested source.
+type ImportResult struct {
+	Email   string
+	Account string
+	Source  string
+	// Rows is how many transactions the source had; Inserted how many of
+	// them were not stored yet.
+	Rows     int
+	Inserted int64
+}
+
+// ReportStatus is what Report did for a user.
+type ReportStatus string
+
+const (
+	ReportQueued      ReportStatus = "queued"
+	ReportAlreadySent ReportStatus = "already_sent"
+	ReportOptedOut    ReportStatus = "opted_out"
+	ReportEmpty       ReportStatus = "no_transactions"
+)
+
+// ReportOptions are per-request overrides; empty fields fall back to the
+// user's stored settings.
+type ReportOptions struct {
+	// Account is the name of the account the CSV is imported into; empty
+	// means the user's default account.
+	Account    string
+	Template   string
+	Locale     string
+	Channel    domain.Channel
+	WebhookURL string
+	// Once makes Report claim its period so the user gets that report at
+	// most once (scheduled runs); it needs a non-zero period.
+	Once bool
+	// RunKey, when set, is claimed instead of the period, so Process and
+	// Report queue at most one report per user and key (e.g. one per S3
+	// object version, however often its event is delivered).
+	RunKey string
+}
+
+// ReportData is what a report is rendered from.
+type ReportData struct {
+	Locale       domain.Locale
+	Summary      domain.MonthlySummary
+	Transactions []domain.Transaction
+}
+
+type DispatchResult struct {
+	Sent    int
+	Retried int
+	Dead    int
+	// DeadErr is the error that dead-lettered the last Dead message.
+	DeadErr error
+	// ByRun breaks the counts down by the correlation id of the run that
+	// queued each message, so a run can tell its own messages from older
+	// ones the same dispatch picked up.
+	ByRun map[string]DispatchResult
+}
+
+// Run returns the outcomes of the messages queued under correlationID.
+func (r DispatchResult) Run(correlationID string) DispatchResult { return r.ByRun[correlationID] }
+
+type OutboxDispatcher interface {
+	Dispatch(ctx context.Context) (DispatchResult, error)
+}
+
+// ScheduleResult counts what a scheduled run did, per user.
+type ScheduleResult struct {
+	Users       int
+	Queued      int
+	AlreadySent int
+	Empty       int
+	Failed      int
+}
+
+// ReportPreferences changes whether users receive reports, e.g. from the
+// unsubscribe link of a report email.
+type ReportPreferences interface {
+	Unsubscribe(ctx context.Context, userID string) error
+}
+
+// BatchJob is one user's share of a batch run. Sources are ingested in order
+// and a single report is queued after the last one.
+type BatchJob struct {
+	Email   string
+	Sources []BatchSource
+	Options ReportOptions
+}
+
+// BatchSource is a CSV of a batch job and the account it is imported into
+// (Options.Account when empty).
+type BatchSource struct {
+	Path    string
+	Account string
+}
+
+// EmailMerge is a stored user email (From) folded into its canonical form (Into).
+type EmailMerge struct {
+	From string
+	Into string
+}
+
+type BatchResult struct {
+	Email    string
+	Sources  []string
+	Err      error
+	Duration time.Duration
+}
@@ -4,4 +4,4 @@ import "context"
 
 type TemplateRender interface {
 	Process(ctx context.Context, userEmail string, csvSourcePath string, templateHtml string) error
-}
\ No newline at end of file
+}
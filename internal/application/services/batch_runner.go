@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/Vasenti/stori_challenge/internal/application/ports"
+	"github.com/Vasenti/stori_challenge/internal/domain"
+)
+
+// BatchRunner processes many users concurrently with a bounded worker pool.
+type BatchRunner struct {
+	svc     ports.TransactionReportService
+	workers int
+	log     *slog.Logger
+}
+
+func NewBatchRunner(svc ports.TransactionReportService, workers int, log *slog.Logger) *BatchRunner {
+	if workers <= 0 {
+		workers = 1
+	}
+	if log == nil {
+		log = slog.Default()
+	}
+	return &BatchRunner{svc: svc, workers: workers, log: log}
+}
+
+// Run processes jobs and returns one result per user, in the order users first
+// appear. Jobs for the same email are merged so a user's sources are never
+// ingested concurrently and only one report is queued per user.
+func (b *BatchRunner) Run(ctx context.Context, jobs []ports.BatchJob) []ports.BatchResult {
+	jobs = mergeJobs(jobs)
+	results := make([]ports.BatchResult, len(jobs))
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(b.workers, len(jobs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = b.runJob(ctx, jobs[i])
+				attrs := []any{"user", jobs[i].Email, "sources", len(jobs[i].Sources), "elapsed", results[i].Duration}
+				if err := results[i].Err; err != nil {
+					b.log.ErrorContext(ctx, "batch user failed", append(attrs, "error", err)...)
+				} else {
+					b.log.InfoContext(ctx, "batch user done", attrs...)
+				}
+			}
+		}()
+	}
+
+	for i := range jobs {
+		if ctx.Err() != nil {
+			results[i] = ports.BatchResult{Email: jobs[i].Email, Sources: paths(jobs[i].Sources), Err: ctx.Err()}
+			continue
+		}
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
+
+func (b *BatchRunner) runJob(ctx context.Context, job ports.BatchJob) ports.BatchResult {
+	start := time.Now()
+	res := ports.BatchResult{Email: job.Email, Sources: paths(job.Sources)}
+	if len(job.Sources) == 0 {
+		res.Err = domain.Errorf(domain.ErrValidation, "no sources")
+		return res
+	}
+
+	// Every source but the last is only ingested; the last one queues the report.
+	last := len(job.Sources) - 1
+	for _, src := range job.Sources[:last] {
+		if _, err := b.svc.Ingest(ctx, job.Email, src.Path, sourceOptions(job.Options, src).Account); err != nil {
+			res.Err = fmt.Errorf("%s: %w", src.Path, err)
+			res.Duration = time.Since(start)
+			return res
+		}
+	}
+	src := job.Sources[last]
+	if err := b.svc.Process(ctx, job.Email, src.Path, sourceOptions(job.Options, src)); err != nil {
+		res.Err = fmt.Errorf("%s: %w", src.Path, err)
+	}
+	res.Duration = time.Since(start)
+	return res
+}
+
+func mergeJobs(jobs []ports.BatchJob) []ports.BatchJob {
+	index := make(map[string]int, len(jobs))
+	var merged []ports.BatchJob
+	for _, j := range jobs {
+		if i, ok := index[j.Email]; ok {
+			merged[i].Sources = append(merged[i].Sources, j.Sources...)
+			continue
+		}
+		index[j.Email] = len(merged)
+		j.Sources = append([]ports.BatchSource(nil), j.Sources...)
+		merged = append(merged, j)
+	}
+	return merged
+}
+
+// sourceOptions returns the job options with src's account, if it names one.
+func sourceOptions(opts ports.ReportOptions, src ports.BatchSource) ports.ReportOptions {
+	if src.Account != "" {
+		opts.Account = src.Account
+	}
+	return opts
+}
+
+func paths(sources []ports.BatchSource) []string {
+	out := make([]string, len(sources))
+	for i, src := range sources {
+		out[i] = src.Path
+	}
+	return out
+}
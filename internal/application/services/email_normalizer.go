@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/Vasenti/stori_challenge/internal/application/ports"
+	"github.com/Vasenti/stori_challenge/internal/domain"
+)
+
+// EmailNormalizer re-keys users stored before emails were canonicalised,
+// merging the duplicates ("Alice@X.com", "alice@x.com ") into one user.
+type EmailNormalizer struct {
+	users     ports.UserRepository
+	foldLocal bool
+	log       *slog.Logger
+}
+
+func NewEmailNormalizer(users ports.UserRepository, foldLocal bool, log *slog.Logger) *EmailNormalizer {
+	if log == nil {
+		log = slog.Default()
+	}
+	return &EmailNormalizer{users: users, foldLocal: foldLocal, log: log}
+}
+
+// Run merges every stored email that is not canonical into its canonical
+// form, one user at a time, and returns the merges done (or, with dryRun,
+// planned). Emails that do not parse are returned as invalid and left alone.
+func (n *EmailNormalizer) Run(ctx context.Context, dryRun bool) (merges []ports.EmailMerge, invalid []string, err error) {
+	emails, err := n.users.Emails(ctx)
+	if err != nil {
+		return nil, nil, domain.Wrap("list users", nil, err)
+	}
+	for _, from := range emails {
+		into, err := domain.ParseEmail(from, n.foldLocal)
+		if err != nil {
+			invalid = append(invalid, from)
+			continue
+		}
+		if into == from {
+			continue
+		}
+		if !dryRun {
+			if err := n.users.Merge(ctx, from, into); err != nil {
+				return merges, invalid, domain.Wrap("merge "+from, nil, err)
+			}
+			n.log.InfoContext(ctx, "user merged", "from", from, "into", into)
+		}
+		merges = append(merges, ports.EmailMerge{From: from, Into: into})
+	}
+	return merges, invalid, nil
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Vasenti/stori_challenge/internal/application/ports"
+)
+
+// fakeUsers is a UserRepository holding only stored emails; Merge records
+// its calls and fails for the emails in failMerge.
+type fakeUsers struct {
+	ports.UserRepository
+	emails    []string
+	failMerge map[string]bool
+	merged    []ports.EmailMerge
+}
+
+func (f *fakeUsers) Emails(context.Context) ([]string, error) { return f.emails, nil }
+
+func (f *fakeUsers) Merge(_ context.Context, from, into string) error {
+	if f.failMerge[from] {
+		return errors.New("merge failed")
+	}
+	f.merged = append(f.merged, ports.EmailMerge{From: from, Into: into})
+	return nil
+}
+
+func TestEmailNormalizerRun(t *testing.T) {
+	stored := []string{"Alice@X.com", "alice@x.com ", "alice@x.com", "not an email", "bob@y.com", "Bob@Y.COM"}
+	tests := []struct {
+		name        string
+		foldLocal   bool
+		dryRun      bool
+		failMerge   string
+		wantMerges  []ports.EmailMerge
+		wantInvalid []string
+		wantErr     bool
+	}{
+		{
+			name:      "duplicates merged into the canonical user",
+			foldLocal: true,
+			wantMerges: []ports.EmailMerge{
+				{From: "Alice@X.com", Into: "alice@x.com"},
+				{From: "alice@x.com ", Into: "alice@x.com"},
+				{From: "Bob@Y.COM", Into: "bob@y.com"},
+			},
+			wantInvalid: []string{"not an email"},
+		},
+		{
+			name: "local part kept without folding",
+			wantMerges: []ports.EmailMerge{
+				{From: "Alice@X.com", Into: "Alice@x.com"},
+				{From: "alice@x.com ", Into: "alice@x.com"},
+				{From: "Bob@Y.COM", Into: "Bob@y.com"},
+			},
+			wantInvalid: []string{"not an email"},
+		},
+		{
+			name:      "dry run plans without merging",
+			foldLocal: true,
+			dryRun:    true,
+			wantMerges: []ports.EmailMerge{
+				{From: "Alice@X.com", Into: "alice@x.com"},
+				{From: "alice@x.com ", Into: "alice@x.com"},
+				{From: "Bob@Y.COM", Into: "bob@y.com"},
+			},
+			wantInvalid: []string{"not an email"},
+		},
+		{
+			name:      "failed merge stops the run",
+			foldLocal: true,
+			failMerge: "alice@x.com ",
+			wantMerges: []ports.EmailMerge{
+				{From: "Alice@X.com", Into: "alice@x.com"},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := &fakeUsers{emails: stored, failMerge: map[string]bool{tt.failMerge: true}}
+			merges, invalid, err := NewEmailNormalizer(users, tt.foldLocal, nil).Run(context.Background(), tt.dryRun)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error: %t", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(merges, tt.wantMerges) {
+				t.Errorf("merges = %v, want %v", merges, tt.wantMerges)
+			}
+			if !reflect.DeepEqual(invalid, tt.wantInvalid) {
+				t.Errorf("invalid = %v, want %v", invalid, tt.wantInvalid)
+			}
+			wantMerged := tt.wantMerges
+			if tt.dryRun {
+				wantMerged = nil
+			}
+			if !reflect.DeepEqual(users.merged, wantMerged) {
+				t.Errorf("Merge called with %v, want %v", users.merged, wantMerged)
+			}
+		})
+	}
+}
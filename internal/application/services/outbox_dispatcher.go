@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/Vasenti/stori_challenge/internal/application/ports"
+	"github.com/Vasenti/stori_challenge/internal/domain"
+	"github.com/Vasenti/stori_challenge/internal/logging"
+	"github.com/Vasenti/stori_challenge/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// claimLease is how long a claimed message stays invisible to other
+// dispatchers while it is being sent.
+const claimLease = 5 * time.Minute
+
+type OutboxDispatcherOptions struct {
+	BatchSize   int
+	MaxAttempts int
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+}
+
+type OutboxDispatcher struct {
+	outbox    ports.OutboxRepository
+	notifiers map[domain.Channel]ports.Notifier
+	opts      OutboxDispatcherOptions
+	log       *slog.Logger
+	now       func() time.Time
+}
+
+// NewOutboxDispatcher delivers each message through the notifier of its
+// channel; messages without a channel go by email.
+func NewOutboxDispatcher(outbox ports.OutboxRepository, notifiers map[domain.Channel]ports.Notifier, opts OutboxDispatcherOptions, log *slog.Logger) ports.OutboxDispatcher {
+	if log == nil {
+		log = slog.Default()
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 50
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	if opts.BackoffBase <= 0 {
+		opts.BackoffBase = 30 * time.Second
+	}
+	if opts.BackoffMax < opts.BackoffBase {
+		opts.BackoffMax = opts.BackoffBase
+	}
+	return &OutboxDispatcher{outbox: outbox, notifiers: notifiers, opts: opts, log: log, now: time.Now}
+}
+
+// Dispatch sends every due pending message, batch by batch, until none are left.
+func (d *OutboxDispatcher) Dispatch(ctx context.Context) (ports.DispatchResult, error) {
+	var res ports.DispatchResult
+	for {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		msgs, err := d.outbox.ClaimDue(ctx, d.now(), d.opts.BatchSize, claimLease)
+		if err != nil {
+			return res, fmt.Errorf("claim outbox: %w", err)
+		}
+		if len(msgs) == 0 {
+			return res, nil
+		}
+
+		for _, m := range msgs {
+			channel := m.Channel
+			if channel == "" {
+				channel = domain.ChannelEmail
+			}
+			// Log under the id of the run that queued the message; keep ours as dispatch_id.
+			mctx := ctx
+			if m.CorrelationID != "" {
+				mctx = logging.WithCorrelationID(ctx, m.CorrelationID)
+			}
+			attrs := []any{"user", m.Recipient, "channel", channel, "outbox_id", m.ID, "dispatch_id", logging.CorrelationID(ctx)}
+
+			sctx, span := telemetry.Start(mctx, "outbox.deliver",
+				attribute.String("channel", string(channel)), attribute.Int64("outbox_id", int64(m.ID)))
+			sendErr := d.send(sctx, channel, m)
+			span.End(sendErr)
+			if sendErr == nil {
+				telemetry.Delivered(ctx, string(channel), "sent")
+				if err := d.outbox.MarkSent(ctx, m.ID, d.now()); err != nil {
+					return res, fmt.Errorf("mark sent %d: %w", m.ID, err)
+				}
+				tally(&res, m, func(r *ports.DispatchResult) { r.Sent++ })
+				d.log.InfoContext(mctx, "report sent", attrs...)
+				continue
+			}
+
+			// An interrupted send is not an attempt; the message is claimed
+			// again once its lease expires.
+			if err := ctx.Err(); err != nil {
+				return res, err
+			}
+
+			// Rejected or malformed messages would fail the same way on every retry.
+			attempts := m.Attempts + 1
+			dead := attempts >= d.opts.MaxAttempts ||
+				errors.Is(sendErr, domain.ErrEmailRejected) || errors.Is(sendErr, domain.ErrValidation)
+			if err := d.outbox.MarkFailed(ctx, m.ID, attempts, d.now().Add(d.backoff(attempts)), sendErr.Error(), dead); err != nil {
+				return res, fmt.Errorf("mark failed %d: %w", m.ID, err)
+			}
+			if dead {
+				telemetry.Delivered(ctx, string(channel), "dead")
+				tally(&res, m, func(r *ports.DispatchResult) { r.Dead++; r.DeadErr = sendErr })
+				d.log.ErrorContext(mctx, "report dead-lettered", append(attrs, "attempts", attempts, "error", sendErr)...)
+			} else {
+				telemetry.Delivered(ctx, string(channel), "failed")
+				tally(&res, m, func(r *ports.DispatchResult) { r.Retried++ })
+				d.log.WarnContext(mctx, "report delivery failed", append(attrs, "attempts", attempts, "error", sendErr)...)
+			}
+		}
+
+		if len(msgs) < d.opts.BatchSize {
+			return res, nil
+		}
+	}
+}
+
+// tally applies f to res and to the counts of the run that queued m.
+func tally(res *ports.DispatchResult, m domain.OutboxMessage, f func(*ports.DispatchResult)) {
+	f(res)
+	if m.CorrelationID == "" {
+		return
+	}
+	if res.ByRun == nil {
+		res.ByRun = map[string]ports.DispatchResult{}
+	}
+	run := res.ByRun[m.CorrelationID]
+	f(&run)
+	res.ByRun[m.CorrelationID] = run
+}
+
+func (d *OutboxDispatcher) send(ctx context.Context, channel domain.Channel, m domain.OutboxMessage) error {
+	notifier, ok := d.notifiers[channel]
+	if !ok {
+		return fmt.Errorf("no notifier for channel %q", channel)
+	}
+	to := m.To
+	if len(to) == 0 {
+		to = []string{m.Recipient}
+	}
+	return notifier.Notify(ctx, ports.Notification{
+		ID:        strconv.FormatUint(uint64(m.ID), 10),
+		UserID:    m.UserID,
+		Recipient: m.Recipient,
+		Email: ports.EmailMessage{
+			To:       to,
+			Cc:       m.Cc,
+			Bcc:      m.Bcc,
+			ReplyTo:  m.ReplyTo,
+			Headers:  m.Headers,
+			Subject:  m.Subject,
+			HTMLBody: m.HTMLBody,
+			Inline:   m.Inline,
+		},
+		WebhookURL: m.WebhookURL,
+		Payload:    []byte(m.Payload),
+	})
+}
+
+// backoff returns BackoffBase * 2^(attempts-1), capped at BackoffMax.
+func (d *OutboxDispatcher) backoff(attempts int) time.Duration {
+	wait := d.opts.BackoffBase
+	for i := 1; i < attempts && wait < d.opts.BackoffMax; i++ {
+		wait *= 2
+	}
+	return min(wait, d.opts.BackoffMax)
+}
@@ -0,0 +1,291 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Vasenti/stori_challenge/internal/application/ports"
+	"github.com/Vasenti/stori_challenge/internal/domain"
+)
+
+var dispatchNow = time.Date(2025, 10, 1, 8, 0, 0, 0, time.UTC)
+
+// memOutbox is an in-memory OutboxRepository with the claim semantics of the
+// SQL one: due pending messages in id order, pushed forward by the lease.
+type memOutbox struct {
+	mu   sync.Mutex
+	msgs map[uint]*domain.OutboxMessage
+}
+
+func newMemOutbox(msgs ...domain.OutboxMessage) *memOutbox {
+	o := &memOutbox{msgs: map[uint]*domain.OutboxMessage{}}
+	for i := range msgs {
+		m := msgs[i]
+		m.ID = uint(i + 1)
+		if m.Status == "" {
+			m.Status = domain.OutboxPending
+		}
+		if m.NextAttemptAt.IsZero() {
+			m.NextAttemptAt = dispatchNow
+		}
+		o.msgs[m.ID] = &m
+	}
+	return o
+}
+
+func (o *memOutbox) Enqueue(_ context.Context, m *domain.OutboxMessage) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	m.ID = uint(len(o.msgs) + 1)
+	o.msgs[m.ID] = m
+	return nil
+}
+
+func (o *memOutbox) ClaimDue(_ context.Context, now time.Time, limit int, lease time.Duration) ([]domain.OutboxMessage, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var ids []uint
+	for id, m := range o.msgs {
+		if m.Status == domain.OutboxPending && !m.NextAttemptAt.After(now) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	var out []domain.OutboxMessage
+	for _, id := range ids[:min(limit, len(ids))] {
+		o.msgs[id].NextAttemptAt = now.Add(lease)
+		out = append(out, *o.msgs[id])
+	}
+	return out, nil
+}
+
+func (o *memOutbox) MarkSent(_ context.Context, id uint, at time.Time) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.msgs[id].Status, o.msgs[id].SentAt = domain.OutboxSent, &at
+	return nil
+}
+
+func (o *memOutbox) MarkFailed(_ context.Context, id uint, attempts int, next time.Time, lastErr string, dead bool) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	m := o.msgs[id]
+	m.Attempts, m.NextAttemptAt, m.LastError = attempts, next, lastErr
+	if dead {
+		m.Status = domain.OutboxDead
+	}
+	return nil
+}
+
+func (o *memOutbox) get(id uint) domain.OutboxMessage {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return *o.msgs[id]
+}
+
+// fakeNotifier fails the notifications whose subject is in errs and records
+// the subjects it was asked to deliver.
+type fakeNotifier struct {
+	errs map[string]error
+	sent []string
+}
+
+func (n *fakeNotifier) Notify(_ context.Context, msg ports.Notification) error {
+	n.sent = append(n.sent, msg.Email.Subject)
+	return n.errs[msg.Email.Subject]
+}
+
+func newTestDispatcher(outbox ports.OutboxRepository, n ports.Notifier, opts OutboxDispatcherOptions) *OutboxDispatcher {
+	d := NewOutboxDispatcher(outbox, map[domain.Channel]ports.Notifier{domain.ChannelEmail: n}, opts, nil).(*OutboxDispatcher)
+	d.now = func() time.Time { return dispatchNow }
+	return d
+}
+
+func TestOutboxDispatcherBackoff(t *testing.T) {
+	d := newTestDispatcher(nil, nil, OutboxDispatcherOptions{BackoffBase: 30 * time.Second, BackoffMax: 5 * time.Minute})
+	for attempts, want := range map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		3:  2 * time.Minute,
+		4:  4 * time.Minute,
+		5:  5 * time.Minute,
+		40: 5 * time.Minute,
+	} {
+		if got := d.backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
+
+func TestOutboxDispatcherOutcomes(t *testing.T) {
+	transient := errors.New("connection reset")
+	tests := []struct {
+		name         string
+		attempts     int
+		err          error
+		want         ports.DispatchResult
+		wantStatus   domain.OutboxStatus
+		wantAttempts int
+		wantNext     time.Time
+	}{
+		{
+			name:       "sent",
+			want:       ports.DispatchResult{Sent: 1},
+			wantStatus: domain.OutboxSent,
+		},
+		{
+			name:         "transient failure stays pending",
+			err:          transient,
+			want:         ports.DispatchResult{Retried: 1},
+			wantStatus:   domain.OutboxPending,
+			wantAttempts: 1,
+			wantNext:     dispatchNow.Add(30 * time.Second),
+		},
+		{
+			name:         "later failure backs off up to the cap",
+			attempts:     3,
+			err:          transient,
+			want:         ports.DispatchResult{Retried: 1},
+			wantStatus:   domain.OutboxPending,
+			wantAttempts: 4,
+			wantNext:     dispatchNow.Add(2 * time.Minute),
+		},
+		{
+			name:         "dead at max attempts",
+			attempts:     4,
+			err:          transient,
+			want:         ports.DispatchResult{Dead: 1, DeadErr: transient},
+			wantStatus:   domain.OutboxDead,
+			wantAttempts: 5,
+		},
+		{
+			name:         "rejected email is dead at once",
+			err:          domain.Wrap("smtp", domain.ErrEmailRejected, errors.New("550 no such user")),
+			want:         ports.DispatchResult{Dead: 1},
+			wantStatus:   domain.OutboxDead,
+			wantAttempts: 1,
+		},
+		{
+			name:         "invalid message is dead at once",
+			err:          domain.Errorf(domain.ErrValidation, "email has no recipients"),
+			want:         ports.DispatchResult{Dead: 1},
+			wantStatus:   domain.OutboxDead,
+			wantAttempts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outbox := newMemOutbox(domain.OutboxMessage{Subject: "report", Recipient: "ana@example.com", Attempts: tt.attempts})
+			n := &fakeNotifier{errs: map[string]error{"report": tt.err}}
+			d := newTestDispatcher(outbox, n, OutboxDispatcherOptions{
+				MaxAttempts: 5,
+				BackoffBase: 30 * time.Second,
+				BackoffMax:  2 * time.Minute,
+			})
+
+			res, err := d.Dispatch(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Sent != tt.want.Sent || res.Retried != tt.want.Retried || res.Dead != tt.want.Dead {
+				t.Errorf("result = %+v, want %+v", res, tt.want)
+			}
+			if tt.want.Dead > 0 && !errors.Is(res.DeadErr, tt.err) {
+				t.Errorf("DeadErr = %v, want %v", res.DeadErr, tt.err)
+			}
+			if len(n.sent) != 1 {
+				t.Errorf("notified %d times, want once", len(n.sent))
+			}
+
+			m := outbox.get(1)
+			if m.Status != tt.wantStatus || m.Attempts != tt.wantAttempts {
+				t.Errorf("message is %s after %d attempts, want %s after %d", m.Status, m.Attempts, tt.wantStatus, tt.wantAttempts)
+			}
+			if tt.wantStatus == domain.OutboxPending && !m.NextAttemptAt.Equal(tt.wantNext) {
+				t.Errorf("next attempt at %s, want %s", m.NextAttemptAt, tt.wantNext)
+			}
+			if tt.err != nil && m.LastError != tt.err.Error() {
+				t.Errorf("last error %q, want %q", m.LastError, tt.err.Error())
+			}
+		})
+	}
+}
+
+func TestOutboxDispatcherDrainsEveryBatch(t *testing.T) {
+	var msgs []domain.OutboxMessage
+	for range 5 {
+		msgs = append(msgs, domain.OutboxMessage{Subject: "report", Recipient: "ana@example.com"})
+	}
+	// Not due yet: left for a later dispatch.
+	msgs = append(msgs, domain.OutboxMessage{Subject: "later", Recipient: "ana@example.com", NextAttemptAt: dispatchNow.Add(time.Hour)})
+	outbox := newMemOutbox(msgs...)
+	n := &fakeNotifier{}
+
+	res, err := newTestDispatcher(outbox, n, OutboxDispatcherOptions{BatchSize: 2}).Dispatch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Sent != 5 || len(n.sent) != 5 {
+		t.Fatalf("sent %d (notified %d), want 5", res.Sent, len(n.sent))
+	}
+	if m := outbox.get(6); m.Status != domain.OutboxPending || m.Attempts != 0 {
+		t.Fatalf("message not due yet is %s after %d attempts", m.Status, m.Attempts)
+	}
+}
+
+func TestOutboxDispatcherByRun(t *testing.T) {
+	rejected := domain.Wrap("smtp", domain.ErrEmailRejected, errors.New("550 no such user"))
+	outbox := newMemOutbox(
+		domain.OutboxMessage{Subject: "ok", Recipient: "a@example.com", CorrelationID: "run-a"},
+		domain.OutboxMessage{Subject: "retry", Recipient: "a@example.com", CorrelationID: "run-a"},
+		domain.OutboxMessage{Subject: "dead", Recipient: "b@example.com", CorrelationID: "run-b"},
+		domain.OutboxMessage{Subject: "ok", Recipient: "b@example.com", CorrelationID: "run-b"},
+		domain.OutboxMessage{Subject: "ok", Recipient: "c@example.com"},
+	)
+	n := &fakeNotifier{errs: map[string]error{"retry": errors.New("timeout"), "dead": rejected}}
+
+	res, err := newTestDispatcher(outbox, n, OutboxDispatcherOptions{}).Dispatch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Sent != 3 || res.Retried != 1 || res.Dead != 1 {
+		t.Errorf("total = %+v, want 3 sent, 1 retried, 1 dead", res)
+	}
+	if a := res.Run("run-a"); a.Sent != 1 || a.Retried != 1 || a.Dead != 0 {
+		t.Errorf("run-a = %+v, want 1 sent, 1 retried", a)
+	}
+	if b := res.Run("run-b"); b.Sent != 1 || b.Retried != 0 || b.Dead != 1 || !errors.Is(b.DeadErr, domain.ErrEmailRejected) {
+		t.Errorf("run-b = %+v, want 1 sent and 1 rejected dead letter", b)
+	}
+	if other := res.Run("run-c"); other.Sent+other.Retried+other.Dead != 0 {
+		t.Errorf("unknown run = %+v, want zero", other)
+	}
+	if len(res.ByRun) != 2 {
+		t.Errorf("ByRun has %d runs, want 2 (messages without a correlation id are only in the total)", len(res.ByRun))
+	}
+}
+
+func TestOutboxDispatcherStopsWhenInterrupted(t *testing.T) {
+	outbox := newMemOutbox(domain.OutboxMessage{Subject: "report", Recipient: "ana@example.com"})
+	ctx, cancel := context.WithCancel(context.Background())
+	n := notifyFunc(func(context.Context, ports.Notification) error {
+		cancel()
+		return context.Canceled
+	})
+
+	if _, err := newTestDispatcher(outbox, n, OutboxDispatcherOptions{}).Dispatch(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	// An interrupted send is not an attempt: the message waits for its lease.
+	if m := outbox.get(1); m.Status != domain.OutboxPending || m.Attempts != 0 {
+		t.Fatalf("interrupted message is %s after %d attempts, want pending after 0", m.Status, m.Attempts)
+	}
+}
+
+type notifyFunc func(context.Context, ports.Notification) error
+
+func (f notifyFunc) Notify(ctx context.Context, n ports.Notification) error { return f(ctx, n) }
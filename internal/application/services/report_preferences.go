@@ -0,0 +1,32 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/Vasenti/stori_challenge/internal/application/ports"
+	"github.com/Vasenti/stori_challenge/internal/domain"
+)
+
+type ReportPreferences struct {
+	users ports.UserRepository
+	log   *slog.Logger
+}
+
+func NewReportPreferences(users ports.UserRepository, log *slog.Logger) ports.ReportPreferences {
+	if log == nil {
+		log = slog.Default()
+	}
+	return &ReportPreferences{users: users, log: log}
+}
+
+// Unsubscribe opts the user out of reports. Their CSVs are still ingested;
+// Process just stops queueing reports for them. Unsubscribing twice, or a
+// user that no longer exists, is not an error.
+func (p *ReportPreferences) Unsubscribe(ctx context.Context, userID string) error {
+	if err := p.users.SetReportOptIn(ctx, userID, false); err != nil {
+		return domain.Wrap("unsubscribe", nil, err)
+	}
+	p.log.InfoContext(ctx, "user unsubscribed", "user_id", userID)
+	return nil
+}
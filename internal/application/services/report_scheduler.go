@@ -0,0 +1,88 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/Vasenti/stori_challenge/internal/application/ports"
+	"github.com/Vasenti/stori_challenge/internal/domain"
+)
+
+// ReportScheduler queues the monthly report of every opted-in user from the
+// transactions already stored, with a bounded worker pool.
+type ReportScheduler struct {
+	users   ports.UserRepository
+	reports ports.TransactionReportService
+	workers int
+	log     *slog.Logger
+}
+
+func NewReportScheduler(users ports.UserRepository, reports ports.TransactionReportService, workers int, log *slog.Logger) *ReportScheduler {
+	if workers <= 0 {
+		workers = 1
+	}
+	if log == nil {
+		log = slog.Default()
+	}
+	return &ReportScheduler{users: users, reports: reports, workers: workers, log: log}
+}
+
+// Run queues month's report for every opted-in user. With a zero month each
+// user gets the month before now in their own timezone. Reports already
+// queued for that month are not queued again, so Run can be retried or
+// scheduled more often than monthly. A failing user does not stop the run.
+func (r *ReportScheduler) Run(ctx context.Context, now time.Time, month domain.Period) (ports.ScheduleResult, error) {
+	users, err := r.users.ListOptedIn(ctx)
+	if err != nil {
+		return ports.ScheduleResult{}, domain.Wrap("list users", nil, err)
+	}
+
+	res := ports.ScheduleResult{Users: len(users)}
+	var mu sync.Mutex
+	next := make(chan domain.User)
+	var wg sync.WaitGroup
+	for range min(r.workers, len(users)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for u := range next {
+				period := month
+				if period.IsZero() {
+					period = domain.PreviousMonth(now, u.Timezone)
+				}
+				status, err := r.reports.Report(ctx, u.Email, period, ports.ReportOptions{Once: true})
+
+				mu.Lock()
+				switch {
+				case err != nil:
+					res.Failed++
+				case status == ports.ReportQueued:
+					res.Queued++
+				case status == ports.ReportAlreadySent:
+					res.AlreadySent++
+				case status == ports.ReportEmpty:
+					res.Empty++
+				}
+				mu.Unlock()
+
+				if err != nil {
+					r.log.ErrorContext(ctx, "scheduled report failed", "user", u.Email, "period", period.Key(), "error", err)
+					continue
+				}
+				r.log.InfoContext(ctx, "scheduled report", "user", u.Email, "period", period.Key(), "status", status)
+			}
+		}()
+	}
+
+	for _, u := range users {
+		if ctx.Err() != nil {
+			break
+		}
+		next <- u
+	}
+	close(next)
+	wg.Wait()
+	return res, ctx.Err()
+}
@@ -1,86 +1,474 @@
 package services
 
 import (
+	"cmp"
 	"context"
-	"fmt"
+	"errors"
 	"io"
+	"log/slog"
 	"time"
 
 	"github.com/Vasenti/stori_challenge/internal/application/ports"
 	"github.com/Vasenti/stori_challenge/internal/domain"
+	"github.com/Vasenti/stori_challenge/internal/i18n"
+	"github.com/Vasenti/stori_challenge/internal/logging"
+	"github.com/Vasenti/stori_challenge/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// StepTimeouts bounds each step of a report run; 0 leaves a step bounded
+// only by the caller's context.
+type StepTimeouts struct {
+	// Read covers opening the source, which downloads S3 objects.
+	Read time.Duration
+	// Parse covers reading and parsing the CSV.
+	Parse time.Duration
+	// DB covers each query step (ensure user, upsert, summary, enqueue).
+	DB time.Duration
+	// Render covers resolving the template and rendering the report.
+	Render time.Duration
+}
+
 type TransactionReportService struct {
-	reader ports.Reader
-	urepo  ports.UserRepository
-	trepo  ports.TransactionRepository
-	email  ports.EmailSender
-	renderHTML func(domain.MonthlySummary, string, string) (string, error)
-	parseCSV   func(io.Reader, string, time.Time) ([]domain.Transaction, error)
+	reader     ports.Reader
+	urepo      ports.UserRepository
+	accounts   ports.AccountRepository
+	trepo      ports.TransactionRepository
+	outbox     ports.OutboxRepository
+	runs       ports.ReportRunRepository
+	tx         ports.Transactor
+	renderHTML func(context.Context, domain.MonthlySummary, []domain.Transaction, string, string, domain.Locale) (string, []domain.EmailAsset, error)
+	renderJSON func(context.Context, domain.MonthlySummary, []domain.Transaction, string, domain.Locale) ([]byte, error)
+	parseCSV   func(context.Context, io.Reader, string, time.Time) ([]domain.Transaction, error)
+	timeouts   StepTimeouts
+	log        *slog.Logger
 }
 
 func NewTransactionReportService(
 	reader ports.Reader,
 	urepo ports.UserRepository,
+	accounts ports.AccountRepository,
 	trepo ports.TransactionRepository,
-	email ports.EmailSender,
-	renderHTML func(domain.MonthlySummary, string, string) (string, error),
-	parseCSV func(io.Reader, string, time.Time) ([]domain.Transaction, error),
+	outbox ports.OutboxRepository,
+	runs ports.ReportRunRepository,
+	tx ports.Transactor,
+	renderHTML func(context.Context, domain.MonthlySummary, []domain.Transaction, string, string, domain.Locale) (string, []domain.EmailAsset, error),
+	renderJSON func(context.Context, domain.MonthlySummary, []domain.Transaction, string, domain.Locale) ([]byte, error),
+	parseCSV func(context.Context, io.Reader, string, time.Time) ([]domain.Transaction, error),
+	timeouts StepTimeouts,
+	log *slog.Logger,
 ) ports.TransactionReportService {
+	if log == nil {
+		log = slog.Default()
+	}
 	return &TransactionReportService{
-		reader:   reader,
-		urepo:   urepo,
-		trepo:   trepo,
-		email:    email,
+		reader:     reader,
+		urepo:      urepo,
+		accounts:   accounts,
+		trepo:      trepo,
+		outbox:     outbox,
+		runs:       runs,
+		tx:         tx,
 		renderHTML: renderHTML,
-		parseCSV: parseCSV,
+		renderJSON: renderJSON,
+		parseCSV:   parseCSV,
+		timeouts:   timeouts,
+		log:        log,
 	}
 }
 
-func (s *TransactionReportService) Process(ctx context.Context, userEmail string, csvSourcePath string, templateHtml string) error {
-	// 1) Ensure user exists or create it
-	if err := s.urepo.Ensure(ctx, userEmail); err != nil {
-		return fmt.Errorf("ensure user: %w", err)
+// Process ingests the CSV and queues the all-time report: the steps of Ingest
+// and then Report, sharing one DB transaction so the transactions and the
+// outgoing report are committed together. The source is read and parsed
+// before the transaction starts; delivery is left to the OutboxDispatcher.
+func (s *TransactionReportService) Process(ctx context.Context, userEmail string, csvSourcePath string, opts ports.ReportOptions) (err error) {
+	ctx, span := telemetry.Start(ctx, "report.process", attribute.String("user", userEmail), attribute.String("source", csvSourcePath))
+	defer func() { span.End(err) }()
+
+	user, acct, transactions, err := s.read(ctx, userEmail, csvSourcePath, opts.Account)
+	if err != nil {
+		return err
 	}
 
-	// 2) Read CSV from source (local FS or S3)
-	rc, err := s.reader.Open(csvSourcePath)
+	var res ports.ImportResult
+	var status ports.ReportStatus
+	err = s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		if res, err = s.store(ctx, user, acct, transactions); err != nil {
+			return err
+		}
+		status, err = s.report(ctx, user, domain.Period{}, opts)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("open source: %w", err)
+		return err
 	}
-	defer rc.Close()
+	span.SetAttributes(attribute.String("report.status", string(status)))
+	telemetry.RowsInserted(ctx, res.Inserted)
+	return nil
+}
 
-	// 3) Parse CSV
-	transactions, err := s.parseCSV(rc, userEmail, time.Now())
+// Ingest reads the CSV at csvSourcePath into the user's account (the default
+// one when account is empty) and stores the transactions not seen before.
+// No report is queued.
+func (s *TransactionReportService) Ingest(ctx context.Context, userEmail string, csvSourcePath string, account string) (res ports.ImportResult, err error) {
+	ctx, span := telemetry.Start(ctx, "report.ingest", attribute.String("user", userEmail), attribute.String("source", csvSourcePath))
+	defer func() { span.End(err) }()
+
+	user, acct, transactions, err := s.read(ctx, userEmail, csvSourcePath, account)
 	if err != nil {
-		return fmt.Errorf("parse csv: %w", err)
+		return ports.ImportResult{}, err
+	}
+	if res, err = s.store(ctx, user, acct, transactions); err != nil {
+		return ports.ImportResult{}, err
 	}
+	res.Source = csvSourcePath
+	telemetry.RowsInserted(ctx, res.Inserted)
+	return res, nil
+}
 
-	// 4) Bulk upsert transactions
-	if err := s.trepo.BulkUpsert(ctx, transactions); err != nil {
-		return fmt.Errorf("bulk upsert: %w", err)
+// Report queues the user's report of period (all time when zero) from the
+// transactions already stored, on the channel resolved from opts and the
+// user's settings. Opted-out users and periods without transactions are
+// skipped. With opts.Once the period (or with opts.RunKey the key) is claimed
+// in the same DB transaction that queues the report, so the user gets it at
+// most once.
+func (s *TransactionReportService) Report(ctx context.Context, userEmail string, period domain.Period, opts ports.ReportOptions) (status ports.ReportStatus, err error) {
+	ctx, span := telemetry.Start(ctx, "report.report", attribute.String("user", userEmail), attribute.String("period", period.Key()))
+	defer func() {
+		span.SetAttributes(attribute.String("report.status", string(status)))
+		span.End(err)
+	}()
+	if opts.Once && period.IsZero() {
+		return "", domain.Errorf(domain.ErrValidation, "a period is required to report it once")
 	}
 
-	// 5) Get monthly summary
-	summary, err := s.trepo.GetMonthlySummary(ctx, userEmail)
+	sctx, end := s.step(ctx, "ensure_user", s.timeouts.DB)
+	user, err := s.urepo.Ensure(sctx, userEmail)
+	end(err)
 	if err != nil {
-		return fmt.Errorf("get monthly summary: %w", err)
+		return "", domain.Wrap("ensure user", nil, err)
 	}
 
-	fmt.Printf("Get monthly summary - balance: %f, avgCredit: %f, avgDebit: %f", summary.BalanceTotal, summary.AvgCredit, summary.AvgDebit)
+	err = s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		status, err = s.report(ctx, user, period, opts)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return status, nil
+}
 
-	// 6) Render HTML
-	htmlBody, err := s.renderHTML(summary, userEmail, templateHtml)
+// report runs the steps of Report for a known user: summary, claim (with
+// opts.Once or opts.RunKey), render and enqueue. Callers run it inside a DB
+// transaction.
+func (s *TransactionReportService) report(ctx context.Context, user domain.User, period domain.Period, opts ports.ReportOptions) (ports.ReportStatus, error) {
+	// Users who unsubscribed keep their data up to date but get no report.
+	if !user.ReportOptIn {
+		s.log.InfoContext(ctx, "report skipped: user opted out", "user", user.Email)
+		return ports.ReportOptedOut, nil
+	}
+	channel, webhookURL, err := delivery(user, opts)
+	if err != nil {
+		return "", err
+	}
+	loc, err := localeFor(user, opts.Locale)
 	if err != nil {
-		return fmt.Errorf("render html: %w", err)
+		return "", err
 	}
 
-	// 7) Send email
-	subject := fmt.Sprintf("Your transaction report - %s", time.Now().Format("January 2006"))
-	if err := s.email.Send(userEmail, subject, htmlBody); err != nil {
-		return fmt.Errorf("send email: %w", err)
+	// 5) Get the summary of the period
+	sctx, end := s.step(ctx, "summary", s.timeouts.DB)
+	summary, err := s.trepo.GetMonthlySummary(sctx, user.ID, period)
+	end(err)
+	if err != nil {
+		return "", domain.Wrap("get monthly summary", nil, err)
+	}
+	if summary.Currency == "" {
+		summary.Currency = user.Currency
+	}
+	if len(summary.TransactionsByMonth) == 0 {
+		s.log.InfoContext(ctx, "report skipped: no transactions", "user", user.Email, "period", period.Key())
+		return ports.ReportEmpty, nil
 	}
+	s.log.InfoContext(ctx, "monthly summary computed", "user", user.Email, "period", period.Key(),
+		"balance", summary.BalanceTotal, "avg_credit", summary.AvgCredit, "avg_debit", summary.AvgDebit)
 
-	fmt.Println("Email sent to", userEmail)
-	return nil
-}
\ No newline at end of file
+	if key := runKey(period, opts); key != "" {
+		sctx, end = s.step(ctx, "claim", s.timeouts.DB)
+		claimed, err := s.runs.Claim(sctx, user.ID, key)
+		end(err)
+		if err != nil {
+			return "", domain.Wrap("claim report run", nil, err)
+		}
+		if !claimed {
+			s.log.InfoContext(ctx, "report skipped: already queued", "user", user.Email, "run_key", key)
+			return ports.ReportAlreadySent, nil
+		}
+	}
+
+	// 6) Render the report for its channel
+	sctx, end = s.step(ctx, "render", s.timeouts.Render, attribute.String("channel", string(channel)))
+	msg, err := s.render(sctx, user, loc, summary, channel, webhookURL, opts.Template)
+	end(err)
+	if err != nil {
+		return "", err
+	}
+
+	// 7) Enqueue the report in the outbox
+	sctx, end = s.step(ctx, "enqueue", s.timeouts.DB)
+	err = s.outbox.Enqueue(sctx, msg)
+	end(err)
+	if err != nil {
+		return "", domain.Wrap("enqueue report", nil, err)
+	}
+	s.log.InfoContext(ctx, "report queued", "user", user.Email, "period", period.Key(), "channel", channel, "outbox_id", msg.ID)
+	return ports.ReportQueued, nil
+}
+
+// runKey is the key a report is claimed under: opts.RunKey, else the period
+// with opts.Once, else none.
+func runKey(period domain.Period, opts ports.ReportOptions) string {
+	if opts.RunKey != "" {
+		return opts.RunKey
+	}
+	if opts.Once {
+		return period.Key()
+	}
+	return ""
+}
+
+// delivery resolves where a report goes. The request's channel wins over the
+// user's; a webhook URL alone implies the webhook channel.
+func delivery(user domain.User, opts ports.ReportOptions) (domain.Channel, string, error) {
+	channel, webhookURL := user.Channel, user.WebhookURL
+	if opts.WebhookURL != "" {
+		channel, webhookURL = domain.ChannelWebhook, opts.WebhookURL
+	}
+	if opts.Channel != "" {
+		channel = opts.Channel
+	}
+	if channel == "" {
+		channel = domain.ChannelEmail
+	}
+	if channel != domain.ChannelWebhook {
+		return channel, "", nil
+	}
+	if webhookURL == "" {
+		return "", "", domain.Errorf(domain.ErrValidation, "webhook channel selected for %s but no webhook url is set", user.Email)
+	}
+	webhookURL, err := domain.ParseWebhookURL(webhookURL)
+	if err != nil {
+		return "", "", err
+	}
+	return channel, webhookURL, nil
+}
+
+// localeFor returns the requested locale, else the user's, else the default.
+// A requested locale without a catalog is a validation error.
+func localeFor(user domain.User, locale string) (domain.Locale, error) {
+	if locale != "" {
+		return domain.LookupLocale(locale)
+	}
+	if user.Locale == "" {
+		return domain.DefaultLocale, nil
+	}
+	return user.Locale, nil
+}
+
+// render builds the outbox message of step 6: a JSON payload for webhooks,
+// an HTML email with its recipients otherwise.
+func (s *TransactionReportService) render(ctx context.Context, user domain.User, loc domain.Locale, summary domain.MonthlySummary, channel domain.Channel, webhookURL, template string) (*domain.OutboxMessage, error) {
+	stored, err := s.trepo.List(ctx, user.ID, summary.Period)
+	if err != nil {
+		return nil, domain.Wrap("list transactions", nil, err)
+	}
+	msg := &domain.OutboxMessage{
+		UserID:        user.ID,
+		Recipient:     user.Email,
+		Channel:       channel,
+		CorrelationID: logging.CorrelationID(ctx),
+		Subject:       i18n.T(loc, "subject", i18n.MonthYear(loc, reportMonth(summary))),
+	}
+	if channel == domain.ChannelWebhook {
+		payload, err := s.renderJSON(ctx, summary, stored, user.Email, loc)
+		if err != nil {
+			return nil, domain.Wrap("render json", nil, err)
+		}
+		msg.WebhookURL = webhookURL
+		msg.Payload = string(payload)
+		return msg, nil
+	}
+
+	htmlBody, inline, err := s.renderHTML(ctx, summary, stored, user.Email, template, loc)
+	if err != nil {
+		return nil, domain.Wrap("render html", nil, err)
+	}
+	msg.To = append([]string{user.Email}, user.Recipients.To...)
+	msg.Cc = user.Recipients.Cc
+	msg.Bcc = user.Recipients.Bcc
+	msg.ReplyTo = user.Recipients.ReplyTo
+	msg.HTMLBody = htmlBody
+	msg.Inline = inline
+	return msg, nil
+}
+
+// Preview parses the CSV and returns the report data of its transactions
+// alone. Nothing is stored: the user and account are looked up, not created,
+// and transactions stored before are left out.
+func (s *TransactionReportService) Preview(ctx context.Context, userEmail string, csvSourcePath string, opts ports.ReportOptions) (data ports.ReportData, err error) {
+	ctx, span := telemetry.Start(ctx, "report.preview", attribute.String("user", userEmail), attribute.String("source", csvSourcePath))
+	defer func() { span.End(err) }()
+
+	sctx, end := s.step(ctx, "find_user", s.timeouts.DB)
+	user, acct, err := s.previewAccount(sctx, userEmail, opts.Account)
+	end(err)
+	if err != nil {
+		return ports.ReportData{}, domain.Wrap("find account", nil, err)
+	}
+	loc, err := localeFor(user, opts.Locale)
+	if err != nil {
+		return ports.ReportData{}, err
+	}
+	transactions, err := s.parse(ctx, userEmail, acct, csvSourcePath)
+	if err != nil {
+		return ports.ReportData{}, err
+	}
+
+	summary := domain.Summarize(transactions)
+	summary.Currency = cmp.Or(acct.Currency, user.Currency)
+	summary.Accounts = []domain.AccountSummary{{
+		Account:      acct,
+		BalanceTotal: summary.BalanceTotal,
+		AvgDebit:     summary.AvgDebit,
+		AvgCredit:    summary.AvgCredit,
+		Transactions: len(transactions),
+	}}
+	return ports.ReportData{Locale: loc, Summary: summary, Transactions: transactions}, nil
+}
+
+// previewAccount is the user and account an import of userEmail into
+// account would use, without creating either: unknown users and a missing
+// default account get unsaved placeholders.
+func (s *TransactionReportService) previewAccount(ctx context.Context, userEmail, account string) (domain.User, domain.Account, error) {
+	user, found, err := s.urepo.FindByEmail(ctx, userEmail)
+	if err != nil {
+		return domain.User{}, domain.Account{}, err
+	}
+	if !found {
+		user = domain.User{Email: userEmail}
+	}
+	if account == "" {
+		account = domain.DefaultAccountName
+	}
+	if found {
+		accounts, err := s.accounts.List(ctx, user.ID)
+		if err != nil {
+			return domain.User{}, domain.Account{}, err
+		}
+		for _, a := range accounts {
+			if a.Name == account {
+				return user, a, nil
+			}
+		}
+	}
+	if account != domain.DefaultAccountName {
+		return domain.User{}, domain.Account{}, domain.Errorf(domain.ErrValidation, "unknown account %q", account)
+	}
+	return user, domain.Account{Name: account, Type: domain.AccountDebit, Currency: user.Currency}, nil
+}
+
+// read runs steps 1-3 of an import: it ensures the user, resolves the target
+// account and parses the CSV source into that account.
+func (s *TransactionReportService) read(ctx context.Context, userEmail, csvSourcePath, account string) (domain.User, domain.Account, []domain.Transaction, error) {
+	// 1) Ensure user exists or create it, and find the account to import into
+	sctx, end := s.step(ctx, "ensure_user", s.timeouts.DB)
+	user, err := s.urepo.Ensure(sctx, userEmail)
+	var acct domain.Account
+	if err == nil {
+		acct, err = s.account(sctx, user, account)
+	}
+	end(err)
+	if err != nil {
+		return domain.User{}, domain.Account{}, nil, domain.Wrap("ensure user", nil, err)
+	}
+
+	transactions, err := s.parse(ctx, userEmail, acct, csvSourcePath)
+	if err != nil {
+		return domain.User{}, domain.Account{}, nil, err
+	}
+	return user, acct, transactions, nil
+}
+
+// parse runs steps 2-3 of an import, reading the CSV source into acct.
+func (s *TransactionReportService) parse(ctx context.Context, userEmail string, acct domain.Account, csvSourcePath string) ([]domain.Transaction, error) {
+	// 2) Read CSV from source (local FS or S3)
+	sctx, end := s.step(ctx, "read_source", s.timeouts.Read, attribute.String("source", csvSourcePath))
+	rc, err := s.reader.Open(sctx, csvSourcePath)
+	end(err)
+	if err != nil {
+		return nil, domain.Wrap("open source", nil, err)
+	}
+	defer rc.Close()
+	s.log.InfoContext(ctx, "source opened", "user", userEmail, "source", csvSourcePath)
+
+	// 3) Parse CSV (the S3 body streams in here)
+	sctx, end = s.step(ctx, "parse", s.timeouts.Parse)
+	transactions, err := s.parseCSV(sctx, rc, acct.ID, time.Now())
+	end(err)
+	if err != nil {
+		// A canceled or timed-out parse says nothing about the CSV itself.
+		kind := domain.ErrValidation
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			kind = nil
+		}
+		return nil, domain.Wrap("parse csv", kind, err)
+	}
+	telemetry.RowsParsed(ctx, len(transactions))
+	s.log.InfoContext(ctx, "csv parsed", "user", userEmail, "account", acct.Name, "rows", len(transactions))
+	return transactions, nil
+}
+
+// store runs step 4 of an import, upserting the parsed transactions.
+func (s *TransactionReportService) store(ctx context.Context, user domain.User, acct domain.Account, transactions []domain.Transaction) (ports.ImportResult, error) {
+	sctx, end := s.step(ctx, "upsert", s.timeouts.DB)
+	n, err := s.trepo.BulkUpsert(sctx, transactions)
+	end(err)
+	if err != nil {
+		return ports.ImportResult{}, domain.Wrap("bulk upsert", nil, err)
+	}
+	s.log.InfoContext(ctx, "transactions upserted", "user", user.Email, "account", acct.Name, "rows", len(transactions), "inserted", n)
+	return ports.ImportResult{Email: user.Email, Account: acct.Name, Rows: len(transactions), Inserted: n}, nil
+}
+
+// account returns the user's account called name: the default account (created
+// on first use) when name is empty, otherwise an existing one.
+func (s *TransactionReportService) account(ctx context.Context, user domain.User, name string) (domain.Account, error) {
+	if name == "" || name == domain.DefaultAccountName {
+		return s.accounts.Default(ctx, user.ID, user.Currency)
+	}
+	return s.accounts.GetByName(ctx, user.ID, name)
+}
+
+// reportMonth is the month a report is titled with: its period's, or the
+// current one for all-time reports.
+func reportMonth(summary domain.MonthlySummary) time.Time {
+	if summary.Period.IsZero() {
+		return time.Now()
+	}
+	return summary.Period.From
+}
+
+// step starts a traced step bounded by timeout (none when 0). The returned
+// func ends the step's span and releases its context.
+func (s *TransactionReportService) step(ctx context.Context, name string, timeout time.Duration, attrs ...attribute.KeyValue) (context.Context, func(error)) {
+	ctx, span := telemetry.StartStep(ctx, name, attrs...)
+	cancel := context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	return ctx, func(err error) {
+		span.End(err)
+		cancel()
+	}
+}
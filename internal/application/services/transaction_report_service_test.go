@@ -0,0 +1,175 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/Vasenti/stori_challenge/internal/application/ports"
+	"github.com/Vasenti/stori_challenge/internal/domain"
+)
+
+// spans records the spans of every test: the global tracer provider can only
+// be installed once per process.
+var spans = tracetest.NewInMemoryExporter()
+
+func TestMain(m *testing.M) {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(spans)))
+	os.Exit(m.Run())
+}
+
+// reportUsers is a UserRepository holding one user.
+type reportUsers struct {
+	ports.UserRepository
+	user domain.User
+}
+
+func (u *reportUsers) Ensure(context.Context, string) (domain.User, error) { return u.user, nil }
+
+type reportAccounts struct{ ports.AccountRepository }
+
+func (reportAccounts) Default(_ context.Context, userID, currency string) (domain.Account, error) {
+	return domain.Account{ID: "acct-1", UserID: userID, Name: domain.DefaultAccountName, Currency: currency}, nil
+}
+
+// reportTransactions stores what BulkUpsert is given and summarises it by
+// month; upsertErr fails BulkUpsert.
+type reportTransactions struct {
+	ports.TransactionRepository
+	stored    []domain.Transaction
+	upsertErr error
+}
+
+func (r *reportTransactions) BulkUpsert(_ context.Context, txs []domain.Transaction) (int64, error) {
+	if r.upsertErr != nil {
+		return 0, r.upsertErr
+	}
+	r.stored = append(r.stored, txs...)
+	return int64(len(txs)), nil
+}
+
+func (r *reportTransactions) GetMonthlySummary(context.Context, string, domain.Period) (domain.MonthlySummary, error) {
+	sum := domain.MonthlySummary{TransactionsByMonth: map[domain.YearMonth]int{}}
+	for _, t := range r.stored {
+		sum.BalanceTotal += t.Amount
+		sum.TransactionsByMonth[domain.YearMonth{Year: t.OccurredAt.Year(), Month: t.OccurredAt.Month()}]++
+	}
+	return sum, nil
+}
+
+func (r *reportTransactions) List(context.Context, string, domain.Period) ([]domain.Transaction, error) {
+	return r.stored, nil
+}
+
+type reportReader struct{}
+
+func (reportReader) Open(context.Context, string) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader("Id,Date,Transaction\n0,7/15,+60.5\n")), nil
+}
+
+type reportTransactor struct{}
+
+func (reportTransactor) WithinTransaction(ctx context.Context, fn func(context.Context) error) error {
+	return fn(ctx)
+}
+
+func newTestReportService(trepo *reportTransactions, outbox ports.OutboxRepository) ports.TransactionReportService {
+	users := &reportUsers{user: domain.User{ID: "user-1", Email: "ana@example.com", ReportOptIn: true}}
+	parse := func(_ context.Context, _ io.Reader, accountID string, _ time.Time) ([]domain.Transaction, error) {
+		at := time.Date(2025, 7, 15, 0, 0, 0, 0, time.UTC)
+		return []domain.Transaction{{ID: 0, AccountID: accountID, OccurredAt: at, Amount: 60.5}}, nil
+	}
+	render := func(context.Context, domain.MonthlySummary, []domain.Transaction, string, string, domain.Locale) (string, []domain.EmailAsset, error) {
+		return "<p>report</p>", nil, nil
+	}
+	return NewTransactionReportService(reportReader{}, users, reportAccounts{}, trepo, outbox, nil, reportTransactor{},
+		render, nil, parse, StepTimeouts{}, nil)
+}
+
+func TestProcessEmitsStepSpans(t *testing.T) {
+	spans.Reset()
+	outbox := newMemOutbox()
+
+	if err := newTestReportService(&reportTransactions{}, outbox).Process(context.Background(), "ana@example.com", "statement.csv", ports.ReportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(outbox.msgs) != 1 {
+		t.Fatalf("queued %d reports, want 1", len(outbox.msgs))
+	}
+
+	got := spans.GetSpans()
+	root := findSpan(got, "report.process")
+	if root == nil {
+		t.Fatalf("no report.process span in %v", spanNames(got))
+	}
+	for _, name := range []string{"ensure_user", "read_source", "parse", "upsert", "summary", "render", "enqueue"} {
+		s := findSpan(got, "report."+name)
+		if s == nil {
+			t.Errorf("no report.%s span in %v", name, spanNames(got))
+			continue
+		}
+		if s.Parent.SpanID() != root.SpanContext.SpanID() {
+			t.Errorf("report.%s is not a child of report.process", name)
+		}
+		if s.Status.Code == codes.Error {
+			t.Errorf("report.%s failed: %s", name, s.Status.Description)
+		}
+	}
+	if s := findSpan(got, "report.claim"); s != nil {
+		t.Errorf("report.claim span without a run key")
+	}
+}
+
+func TestProcessMarksTheFailedStep(t *testing.T) {
+	spans.Reset()
+	trepo := &reportTransactions{upsertErr: errors.New("connection reset")}
+
+	if err := newTestReportService(trepo, newMemOutbox()).Process(context.Background(), "ana@example.com", "statement.csv", ports.ReportOptions{}); err == nil {
+		t.Fatal("Process succeeded with a failing upsert")
+	}
+
+	got := spans.GetSpans()
+	for name, want := range map[string]codes.Code{
+		"report.parse":   codes.Unset,
+		"report.upsert":  codes.Error,
+		"report.process": codes.Error,
+	} {
+		s := findSpan(got, name)
+		if s == nil {
+			t.Errorf("no %s span in %v", name, spanNames(got))
+			continue
+		}
+		if s.Status.Code != want {
+			t.Errorf("%s status = %s, want %s", name, s.Status.Code, want)
+		}
+	}
+	if s := findSpan(got, "report.summary"); s != nil {
+		t.Errorf("report.summary ran after the upsert failed")
+	}
+}
+
+func findSpan(spans tracetest.SpanStubs, name string) *tracetest.SpanStub {
+	for i := range spans {
+		if spans[i].Name == name {
+			return &spans[i]
+		}
+	}
+	return nil
+}
+
+func spanNames(spans tracetest.SpanStubs) []string {
+	var names []string
+	for _, s := range spans {
+		names = append(names, s.Name)
+	}
+	return names
+}
@@ -1,9 +1,29 @@
 package config
 
-import "github.com/caarlos0/env/v10"
+import (
+	"os"
+
+	"github.com/caarlos0/env/v10"
+)
 
 type Config struct {
-	AppEnv   string `env:"APP_ENV,notEmpty" envDefault:"dev"`
+	AppEnv string `env:"APP_ENV,notEmpty" envDefault:"dev"`
+
+	// Logging: level debug|info|warn|error, format json|text
+	LogLevel  string `env:"LOG_LEVEL" envDefault:"info"`
+	LogFormat string `env:"LOG_FORMAT" envDefault:"json"`
+	// Queries slower than this are logged at warn (0 disables)
+	DBSlowQueryMillis int `env:"DB_SLOW_QUERY_MS" envDefault:"500"`
+
+	// Telemetry: OTLP/HTTP export of traces and metrics is on when an OTLP
+	// endpoint is set; MetricsAddr serves Prometheus /metrics from the CLI
+	ServiceName  string `env:"OTEL_SERVICE_NAME" envDefault:"transaction-manager"`
+	OTLPEndpoint string `env:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+	MetricsAddr  string `env:"METRICS_ADDR"`
+
+	// Users are keyed by their canonical email; with this set the local part
+	// is case-insensitive too (Alice@x.com and alice@x.com are the same user)
+	EmailCaseInsensitiveLocal bool `env:"EMAIL_CASE_INSENSITIVE_LOCAL" envDefault:"true"`
 
 	// DB
 	DBHost            string `env:"DB_HOST,notEmpty"`
@@ -22,6 +42,8 @@ type Config struct {
 	S3AccessKey      string `env:"S3_ACCESS_KEY"`
 	S3SecretKey      string `env:"S3_SECRET_KEY"`
 	S3ForcePathStyle bool   `env:"S3_FORCE_PATH_STYLE" envDefault:"false"`
+	// Object metadata (x-amz-meta-<key>) or tag naming the user of an uploaded CSV
+	S3EmailKey string `env:"S3_EMAIL_KEY" envDefault:"user-email"`
 
 	// SMTP
 	SMTPHost     string `env:"SMTP_HOST" envDefault:"localhost"`
@@ -29,11 +51,72 @@ type Config struct {
 	SMTPUsername string `env:"SMTP_USERNAME"`
 	SMTPPassword string `env:"SMTP_PASSWORD"`
 	SMTPFrom     string `env:"SMTP_FROM,notEmpty" envDefault:"no-reply@example.com"`
+	// Whole SMTP session, from dial to QUIT (0 disables)
+	SMTPTimeoutSecs int `env:"SMTP_TIMEOUT_SECS" envDefault:"30"`
+
+	// Webhook channel: requests are signed with HMAC-SHA256 using WebhookSecret
+	WebhookSecret      string `env:"WEBHOOK_SECRET"`
+	WebhookTimeoutSecs int    `env:"WEBHOOK_TIMEOUT_SECS" envDefault:"10"`
+	WebhookMaxRetries  int    `env:"WEBHOOK_MAX_RETRIES" envDefault:"2"`
+
+	// Report emails carry a one-click unsubscribe link, signed with
+	// UnsubscribeSecret, when both are set; the `serve` command answers it
+	UnsubscribeSecret  string `env:"UNSUBSCRIBE_SECRET"`
+	UnsubscribeBaseURL string `env:"UNSUBSCRIBE_BASE_URL"`
+	HTTPAddr           string `env:"HTTP_ADDR" envDefault:":8080"`
 
+	// Default template reference: a name, a local file or an s3:// object
 	ReportTemplatePath string `env:"REPORT_TEMPLATE_PATH"`
+	// Where named templates live (local dir or s3:// prefix); falls back to the embedded ones
+	ReportTemplateDir       string `env:"REPORT_TEMPLATE_DIR"`
+	ReportTemplateCacheSecs int    `env:"REPORT_TEMPLATE_CACHE_SECS" envDefault:"300"`
+	// Transaction detail exposed to templates (0 rows hides the list)
+	ReportDetailMaxRows int `env:"REPORT_DETAIL_MAX_ROWS" envDefault:"20"`
+	ReportTopN          int `env:"REPORT_TOP_N" envDefault:"3"`
+	// Charts in the report: svg (inline SVG, HTML bars for Outlook), html or off
+	ReportCharts string `env:"REPORT_CHARTS" envDefault:"svg"`
+
+	// Per-step timeouts of a report run (0 disables): opening the source
+	// (S3 downloads included), parsing the CSV, each DB step and rendering
+	SourceTimeoutSecs int `env:"SOURCE_TIMEOUT_SECS" envDefault:"60"`
+	ParseTimeoutSecs  int `env:"PARSE_TIMEOUT_SECS" envDefault:"30"`
+	DBStepTimeoutSecs int `env:"DB_STEP_TIMEOUT_SECS" envDefault:"15"`
+	RenderTimeoutSecs int `env:"RENDER_TIMEOUT_SECS" envDefault:"15"`
+
+	// Users processed concurrently by the batch command
+	BatchWorkers int `env:"BATCH_WORKERS" envDefault:"4"`
+
+	// Outbox dispatcher
+	OutboxBatchSize       int `env:"OUTBOX_BATCH_SIZE" envDefault:"50"`
+	OutboxMaxAttempts     int `env:"OUTBOX_MAX_ATTEMPTS" envDefault:"5"`
+	OutboxBackoffBaseSecs int `env:"OUTBOX_BACKOFF_BASE_SECS" envDefault:"30"`
+	OutboxBackoffMaxSecs  int `env:"OUTBOX_BACKOFF_MAX_SECS" envDefault:"3600"`
 }
 
+// Load reads the config from the environment, over the config file named by
+// CONFIG_FILE when it is set.
 func Load() (*Config, error) {
+	return LoadFile(os.Getenv("CONFIG_FILE"))
+}
+
+// LoadFile reads the config from the environment, over the YAML or TOML file
+// at path (none when empty): a variable that is set wins over the file, and
+// the file wins over the defaults. The returned Config holds whatever could
+// be read even when err is not nil.
+func LoadFile(path string) (*Config, error) {
 	cfg := &Config{}
-	return cfg, env.Parse(cfg)
-}
\ No newline at end of file
+	environ := env.ToMap(os.Environ())
+	if path != "" {
+		vars, err := readFile(path)
+		if err != nil {
+			_ = env.Parse(cfg)
+			return cfg, err
+		}
+		for k, v := range vars {
+			if _, set := environ[k]; !set {
+				environ[k] = v
+			}
+		}
+	}
+	return cfg, env.ParseWithOptions(cfg, env.Options{Environment: environ})
+}
@@ -0,0 +1,42 @@
+package domain
+
+import (
+	"strings"
+	"time"
+)
+
+// AccountType is the kind of product an account is.
+type AccountType string
+
+const (
+	AccountDebit  AccountType = "debit"
+	AccountCredit AccountType = "credit"
+)
+
+// DefaultAccountName is the account imports go to when none is named; it is
+// created on first use.
+const DefaultAccountName = "main"
+
+// Account is one of a user's products (a debit account, a credit card).
+// Transactions belong to an account; Name is unique per user.
+type Account struct {
+	ID     string      `gorm:"primaryKey;type:uuid"`
+	UserID string      `gorm:"type:uuid;not null;uniqueIndex:idx_accounts_user_name,priority:1"`
+	Name   string      `gorm:"size:100;not null;uniqueIndex:idx_accounts_user_name,priority:2"`
+	Type   AccountType `gorm:"size:16;not null;default:debit"`
+	// Currency is the ISO 4217 code of the account's amounts.
+	Currency     string `gorm:"size:3"`
+	CreatedAt    time.Time
+	Transactions []Transaction `gorm:"foreignKey:AccountID;references:ID"`
+}
+
+func (Account) TableName() string { return "accounts" }
+
+// ParseAccountType validates s; empty means "not chosen" and is returned as is.
+func ParseAccountType(s string) (AccountType, error) {
+	switch t := AccountType(strings.ToLower(strings.TrimSpace(s))); t {
+	case "", AccountDebit, AccountCredit:
+		return t, nil
+	}
+	return "", Errorf(ErrValidation, "unknown account type %q (debit, credit)", s)
+}
@@ -0,0 +1,37 @@
+package domain
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Channel is how a report is delivered.
+type Channel string
+
+const (
+	ChannelEmail   Channel = "email"
+	ChannelWebhook Channel = "webhook"
+)
+
+// ParseChannel validates s; empty means "not chosen" and is returned as is.
+func ParseChannel(s string) (Channel, error) {
+	switch c := Channel(strings.ToLower(strings.TrimSpace(s))); c {
+	case "", ChannelEmail, ChannelWebhook:
+		return c, nil
+	}
+	return "", Errorf(ErrValidation, "unknown channel %q (email, webhook)", s)
+}
+
+// ParseWebhookURL validates a webhook URL: it must be absolute https with a
+// host. Empty is returned as is.
+func ParseWebhookURL(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", nil
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme != "https" || u.Hostname() == "" || u.User != nil {
+		return "", Errorf(ErrValidation, "invalid webhook url %q (want https://host/...)", s)
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,34 @@
+package domain
+
+import (
+	"net/mail"
+	"strings"
+)
+
+// Length limits of RFC 5321 (4.5.3.1) for a deliverable address.
+const (
+	maxEmailLen = 254
+	maxLocalLen = 64
+)
+
+// ParseEmail validates raw as a bare RFC 5322 address (no display name,
+// angle brackets or quoted local part) and returns its canonical form:
+// trimmed, with the domain lower-cased and, when foldLocal is set, the local
+// part too. Users are keyed by the canonical form, so every boundary must
+// parse emails through here.
+func ParseEmail(raw string, foldLocal bool) (string, error) {
+	s := strings.TrimSpace(raw)
+	addr, err := mail.ParseAddress(s)
+	if err != nil || addr.Name != "" || addr.Address != s {
+		return "", Errorf(ErrValidation, "invalid email %q", raw)
+	}
+	at := strings.LastIndexByte(s, '@')
+	local, host := s[:at], s[at+1:]
+	if len(s) > maxEmailLen || len(local) > maxLocalLen {
+		return "", Errorf(ErrValidation, "invalid email %q: too long", raw)
+	}
+	if foldLocal {
+		local = strings.ToLower(local)
+	}
+	return local + "@" + strings.ToLower(host), nil
+}
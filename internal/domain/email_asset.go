@@ -0,0 +1,10 @@
+package domain
+
+// EmailAsset is a file sent inside the email as a multipart/related part and
+// referenced from the HTML body as "cid:<ContentID>".
+type EmailAsset struct {
+	ContentID   string
+	ContentType string
+	Filename    string
+	Data        []byte
+}
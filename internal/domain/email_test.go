@@ -0,0 +1,48 @@
+package domain
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseEmail(t *testing.T) {
+	local64 := strings.Repeat("a", 64)
+	tests := []struct {
+		name      string
+		raw       string
+		foldLocal bool
+		want      string
+		wantErr   bool
+	}{
+		{name: "canonical", raw: "ana@example.com", want: "ana@example.com"},
+		{name: "trimmed", raw: "  ana@example.com\t\n", want: "ana@example.com"},
+		{name: "domain lower-cased", raw: "Ana@Example.COM", want: "Ana@example.com"},
+		{name: "local part folded", raw: "Ana.Lopez@Example.com", foldLocal: true, want: "ana.lopez@example.com"},
+		{name: "plus tag kept", raw: "ana+reports@example.com", want: "ana+reports@example.com"},
+		{name: "display name", raw: "Ana <ana@example.com>", wantErr: true},
+		{name: "angle brackets", raw: "<ana@example.com>", wantErr: true},
+		{name: "quoted local part", raw: `"ana lopez"@example.com`, wantErr: true},
+		{name: "no at", raw: "ana.example.com", wantErr: true},
+		{name: "no domain", raw: "ana@", wantErr: true},
+		{name: "empty", raw: "  ", wantErr: true},
+		{name: "two addresses", raw: "ana@example.com, bob@example.com", wantErr: true},
+		{name: "local part at limit", raw: local64 + "@example.com", want: local64 + "@example.com"},
+		{name: "local part too long", raw: local64 + "a@example.com", wantErr: true},
+		{name: "address at limit", raw: local64 + "@" + strings.Repeat("b", 185) + ".com", want: local64 + "@" + strings.Repeat("b", 185) + ".com"},
+		{name: "address too long", raw: local64 + "@" + strings.Repeat("b", 186) + ".com", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseEmail(tt.raw, tt.foldLocal)
+			if tt.wantErr {
+				if Code(err) != "validation" {
+					t.Fatalf("ParseEmail(%q) = %q, %v; want a validation error", tt.raw, got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("ParseEmail(%q) = %q, %v; want %q", tt.raw, got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error kinds. Adapters and services tag failures with one of them so callers
+// can branch with errors.Is and report a stable Code.
+var (
+	ErrValidation      = errors.New("invalid input")
+	ErrSourceNotFound  = errors.New("source not found")
+	ErrDBUnavailable   = errors.New("database unavailable")
+	ErrEmailRejected   = errors.New("email rejected")
+	ErrTemplateInvalid = errors.New("invalid template")
+)
+
+// Error is a failure of operation Op (e.g. "parse csv"). Kind is one of the
+// Err* kinds, or nil when the kind, if any, comes from Err's own chain.
+type Error struct {
+	Op   string
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Op == "" {
+		return e.Err.Error()
+	}
+	return e.Op + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Kind == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// Wrap reports err as a failure of op, tagged with kind (which may be nil).
+// A nil err stays nil.
+func Wrap(op string, kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Op: op, Kind: kind, Err: err}
+}
+
+// Errorf returns a new error of the given kind.
+func Errorf(kind error, format string, args ...any) error {
+	return &Error{Kind: kind, Err: fmt.Errorf(format, args...)}
+}
+
+// Code returns the machine-readable code of err's outermost kind:
+// "validation", "source_not_found", "db_unavailable", "email_rejected",
+// "template_invalid", or "internal" for untagged errors.
+func Code(err error) string {
+	if err == nil {
+		return ""
+	}
+	switch kindOf(err) {
+	case ErrValidation:
+		return "validation"
+	case ErrSourceNotFound:
+		return "source_not_found"
+	case ErrDBUnavailable:
+		return "db_unavailable"
+	case ErrEmailRejected:
+		return "email_rejected"
+	case ErrTemplateInvalid:
+		return "template_invalid"
+	}
+	return "internal"
+}
+
+// kindOf walks err's tree depth-first, so the kind closest to the caller wins
+// (a missing template file is an invalid template, not a missing source).
+func kindOf(err error) error {
+	switch err {
+	case ErrValidation, ErrSourceNotFound, ErrDBUnavailable, ErrEmailRejected, ErrTemplateInvalid:
+		return err
+	}
+	switch x := err.(type) {
+	case interface{ Unwrap() error }:
+		if inner := x.Unwrap(); inner != nil {
+			return kindOf(inner)
+		}
+	case interface{ Unwrap() []error }:
+		for _, inner := range x.Unwrap() {
+			if k := kindOf(inner); k != nil {
+				return k
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package domain
+
+import "strings"
+
+type Locale string
+
+const (
+	LocaleEnUS Locale = "en-US"
+	LocaleEsMX Locale = "es-MX"
+
+	DefaultLocale = LocaleEnUS
+)
+
+// ParseLocale normalises tags like "es", "es_mx" or "EN-us" to a supported
+// Locale, falling back to DefaultLocale when the language is unknown.
+func ParseLocale(s string) Locale {
+	tag := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(s), "_", "-"))
+	switch {
+	case tag == "":
+		return DefaultLocale
+	case strings.HasPrefix(tag, "es"):
+		return LocaleEsMX
+	case strings.HasPrefix(tag, "en"):
+		return LocaleEnUS
+	}
+	return DefaultLocale
+}
+
+// Locales lists the supported locales, the ones with a message catalog.
+var Locales = []Locale{LocaleEnUS, LocaleEsMX}
+
+// LookupLocale is the strict form of ParseLocale for stored or requested
+// locales: s must name a supported locale ("es-MX", "es_mx") or just its
+// language ("es"); anything else is a validation error.
+func LookupLocale(s string) (Locale, error) {
+	tag := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(s), "_", "-"))
+	for _, l := range Locales {
+		if tag == strings.ToLower(string(l)) || tag == l.Lang() {
+			return l, nil
+		}
+	}
+	names := make([]string, len(Locales))
+	for i, l := range Locales {
+		names[i] = string(l)
+	}
+	return "", Errorf(ErrValidation, "unsupported locale %q (%s)", s, strings.Join(names, ", "))
+}
+
+// Lang returns the language subtag, e.g. "es" for es-MX.
+func (l Locale) Lang() string {
+	lang, _, _ := strings.Cut(string(l), "-")
+	return lang
+}
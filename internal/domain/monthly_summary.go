@@ -1,10 +1,81 @@
 package domain
 
-import "time"
+import "math"
 
 type MonthlySummary struct {
+	// Period is the range summarised; zero means all time.
+	Period Period
+	// Currency is the ISO 4217 code every amount is in; empty means the
+	// user's.
+	Currency            string
 	BalanceTotal        float64
-	TransactionsByMonth map[time.Month]int
-	AvgDebit            float64 
+	TransactionsByMonth map[YearMonth]int
+	AvgDebit            float64
 	AvgCredit           float64
-}
\ No newline at end of file
+	// CreditsByMonth and DebitsByMonth hold the summed amounts per month;
+	// debits are absolute values.
+	CreditsByMonth map[YearMonth]float64
+	DebitsByMonth  map[YearMonth]float64
+	// OpeningBalance is the balance carried into Period: the sum of every
+	// transaction before Period.From. It is zero for all-time summaries.
+	OpeningBalance float64
+	// Accounts breaks the totals above down per account, in account name order.
+	Accounts []AccountSummary
+}
+
+// AccountSummary is one account's share of a MonthlySummary.
+type AccountSummary struct {
+	Account      Account
+	BalanceTotal float64
+	AvgDebit     float64
+	AvgCredit    float64
+	Transactions int
+}
+
+// Summarize totals txs: the balance, the credit and debit averages and the
+// per-month breakdown. Period, Currency and Accounts are left for the caller.
+func Summarize(txs []Transaction) MonthlySummary {
+	trxByMonth := make(map[YearMonth]int, 12)
+	creditsByMonth := make(map[YearMonth]float64, 12)
+	debitsByMonth := make(map[YearMonth]float64, 12)
+
+	var balance float64
+	var sumCredits float64
+	var cntCredits int
+	var sumDebitsAbs float64
+	var cntDebits int
+
+	for _, t := range txs {
+		balance += t.Amount
+		month := YearMonthOf(t.OccurredAt)
+
+		trxByMonth[month]++
+
+		if t.Amount > 0 {
+			sumCredits += t.Amount
+			cntCredits++
+			creditsByMonth[month] += t.Amount
+		} else if t.Amount < 0 {
+			sumDebitsAbs += math.Abs(t.Amount)
+			cntDebits++
+			debitsByMonth[month] += math.Abs(t.Amount)
+		}
+	}
+
+	var avgCredit, avgDebit float64
+	if cntCredits > 0 {
+		avgCredit = sumCredits / float64(cntCredits)
+	}
+	if cntDebits > 0 {
+		avgDebit = sumDebitsAbs / float64(cntDebits)
+	}
+
+	return MonthlySummary{
+		BalanceTotal:        balance,
+		TransactionsByMonth: trxByMonth,
+		AvgDebit:            avgDebit,
+		AvgCredit:           avgCredit,
+		CreditsByMonth:      creditsByMonth,
+		DebitsByMonth:       debitsByMonth,
+	}
+}
@@ -0,0 +1,43 @@
+package domain
+
+import "time"
+
+type OutboxStatus string
+
+const (
+	OutboxPending OutboxStatus = "pending"
+	OutboxSent    OutboxStatus = "sent"
+	OutboxDead    OutboxStatus = "dead"
+)
+
+// OutboxMessage is a rendered report waiting to be delivered by the
+// dispatcher: an email, or a JSON Payload posted to WebhookURL.
+type OutboxMessage struct {
+	ID      uint    `gorm:"primaryKey"`
+	Channel Channel `gorm:"not null;size:16;default:email"`
+	// UserID is the user the report belongs to and Recipient their email when
+	// it was queued; To/Cc/Bcc are the addresses it is delivered to (To falls
+	// back to Recipient when empty).
+	UserID        string            `gorm:"index;type:uuid"`
+	Recipient     string            `gorm:"index;not null;size:320"`
+	To            []string          `gorm:"type:text;serializer:json"`
+	Cc            []string          `gorm:"type:text;serializer:json"`
+	Bcc           []string          `gorm:"type:text;serializer:json"`
+	ReplyTo       string            `gorm:"size:320"`
+	Headers       map[string]string `gorm:"type:text;serializer:json"`
+	Subject       string            `gorm:"not null"`
+	HTMLBody      string            `gorm:"type:text;not null"`
+	Inline        []EmailAsset      `gorm:"type:text;serializer:json"`
+	WebhookURL    string            `gorm:"size:2048"`
+	Payload       string            `gorm:"type:text"`
+	Status        OutboxStatus      `gorm:"index:idx_outbox_due,priority:1;not null;size:16"`
+	Attempts      int               `gorm:"not null;default:0"`
+	NextAttemptAt time.Time         `gorm:"index:idx_outbox_due,priority:2;not null"`
+	LastError     string            `gorm:"type:text"`
+	// CorrelationID ties delivery logs to the run that queued the message.
+	CorrelationID string `gorm:"size:64"`
+	CreatedAt     time.Time
+	SentAt        *time.Time
+}
+
+func (OutboxMessage) TableName() string { return "outbox" }
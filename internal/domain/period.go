@@ -0,0 +1,69 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Period is the half-open time range [From, To) a report covers. The zero
+// Period means all time.
+type Period struct {
+	From time.Time
+	To   time.Time
+}
+
+func (p Period) IsZero() bool { return p.From.IsZero() && p.To.IsZero() }
+
+// Key identifies a month period ("2025-09"); it is empty for all time.
+func (p Period) Key() string {
+	if p.IsZero() {
+		return ""
+	}
+	return p.From.Format("2006-01")
+}
+
+// YearMonth is a calendar month of a given year. Per-month totals are keyed
+// by it so periods longer than a year keep January 2024 apart from January
+// 2025.
+type YearMonth struct {
+	Year  int
+	Month time.Month
+}
+
+// YearMonthOf is the month t falls in, in t's location.
+func YearMonthOf(t time.Time) YearMonth { return YearMonth{Year: t.Year(), Month: t.Month()} }
+
+func (m YearMonth) Before(o YearMonth) bool {
+	return m.Year < o.Year || m.Year == o.Year && m.Month < o.Month
+}
+
+// String formats m as "2025-09".
+func (m YearMonth) String() string { return fmt.Sprintf("%04d-%02d", m.Year, int(m.Month)) }
+
+// MonthPeriod is the calendar month year/month with bounds in loc.
+func MonthPeriod(year int, month time.Month, loc *time.Location) Period {
+	from := time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	return Period{From: from, To: from.AddDate(0, 1, 0)}
+}
+
+// PreviousMonth is the month before the one now falls in for a user in
+// timezone tz (now's own location when tz is empty or unknown). Its bounds
+// are in now's location, which is where CSV dates are parsed.
+func PreviousMonth(now time.Time, tz string) Period {
+	local := now
+	if loc, err := time.LoadLocation(tz); tz != "" && err == nil {
+		local = now.In(loc)
+	}
+	first := time.Date(local.Year(), local.Month(), 1, 0, 0, 0, 0, now.Location()).AddDate(0, -1, 0)
+	return MonthPeriod(first.Year(), first.Month(), now.Location())
+}
+
+// ParseMonth parses "YYYY-MM" into its month period in loc.
+func ParseMonth(s string, loc *time.Location) (Period, error) {
+	t, err := time.ParseInLocation("2006-01", strings.TrimSpace(s), loc)
+	if err != nil {
+		return Period{}, Errorf(ErrValidation, "invalid month %q (want YYYY-MM)", s)
+	}
+	return MonthPeriod(t.Year(), t.Month(), loc), nil
+}
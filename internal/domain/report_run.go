@@ -0,0 +1,14 @@
+package domain
+
+import "time"
+
+// ReportRun records that a user's report for a period was queued, so
+// scheduled runs never send the same month twice. Period is the period key
+// (YYYY-MM) or the run key the report was queued under.
+type ReportRun struct {
+	UserID    string `gorm:"primaryKey;type:uuid"`
+	Period    string `gorm:"primaryKey"`
+	CreatedAt time.Time
+}
+
+func (ReportRun) TableName() string { return "report_runs" }
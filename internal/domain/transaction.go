@@ -1,10 +1,13 @@
 package domain
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 type Transaction struct {
 	ID         uint      `gorm:"primaryKey;autoIncrement:false"`
-	UserEmail  string    `gorm:"primaryKey;index;not null"`
+	AccountID  string    `gorm:"primaryKey;type:uuid;index;not null"`
 	OccurredAt time.Time `gorm:"index;not null"`
 	Amount     float64   `gorm:"not null"`
 	RawDate    string    `gorm:"not null"`
@@ -12,3 +15,20 @@ type Transaction struct {
 }
 
 func (Transaction) TableName() string { return "transactions" }
+
+// Sign picks credits (positive amounts) or debits (negative amounts).
+type Sign string
+
+const (
+	SignCredit Sign = "credit"
+	SignDebit  Sign = "debit"
+)
+
+// ParseSign validates s; empty means either sign and is returned as is.
+func ParseSign(s string) (Sign, error) {
+	switch v := Sign(strings.ToLower(strings.TrimSpace(s))); v {
+	case "", SignCredit, SignDebit:
+		return v, nil
+	}
+	return "", Errorf(ErrValidation, "unknown sign %q (credit, debit)", s)
+}
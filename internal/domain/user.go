@@ -1,8 +1,63 @@
 package domain
 
+import (
+	"strings"
+	"time"
+)
+
+// User is keyed by a stable ID (a UUID); Email is a mutable, unique attribute
+// (see ParseEmail for its canonical form).
 type User struct {
-	Email string `gorm:"primaryKey;uniqueIndex;size:320"`
-	Transactions []Transaction `gorm:"foreignKey:UserEmail;references:Email"`
+	ID     string `gorm:"primaryKey;type:uuid"`
+	Email  string `gorm:"uniqueIndex;not null;size:320"`
+	Name   string `gorm:"size:200"`
+	Locale Locale `gorm:"size:16"`
+	// Timezone is an IANA name ("America/Mexico_City"); empty means UTC.
+	Timezone string `gorm:"size:64"`
+	// Currency is the ISO 4217 code amounts are shown in; empty means the locale's.
+	Currency string `gorm:"size:3"`
+	// ReportOptIn is whether the user wants reports at all.
+	ReportOptIn bool `gorm:"not null;default:true"`
+	// Channel is how the user's reports are delivered (email when empty);
+	// WebhookURL is where the webhook channel posts them.
+	Channel    Channel          `gorm:"size:16"`
+	WebhookURL string           `gorm:"size:2048"`
+	Recipients ReportRecipients `gorm:"embedded;embeddedPrefix:report_"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Accounts   []Account `gorm:"foreignKey:UserID;references:ID"`
+}
+
+func (User) TableName() string { return "users" }
+
+// ReportRecipients holds who, besides the user, receives the user's reports.
+type ReportRecipients struct {
+	To      []string `gorm:"type:text;serializer:json"`
+	Cc      []string `gorm:"type:text;serializer:json"`
+	Bcc     []string `gorm:"type:text;serializer:json"`
+	ReplyTo string   `gorm:"size:320"`
 }
 
-func (User) TableName() string { return "users" }
\ No newline at end of file
+// ParseTimezone validates an IANA timezone name; empty is returned as is.
+func ParseTimezone(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", nil
+	}
+	if _, err := time.LoadLocation(s); err != nil {
+		return "", Errorf(ErrValidation, "unknown timezone %q", s)
+	}
+	return s, nil
+}
+
+// ParseCurrency validates an ISO 4217 code ("mxn" → "MXN"); empty is returned as is.
+func ParseCurrency(s string) (string, error) {
+	c := strings.ToUpper(strings.TrimSpace(s))
+	if c == "" {
+		return "", nil
+	}
+	if len(c) != 3 || strings.Trim(c, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return "", Errorf(ErrValidation, "invalid currency %q (ISO 4217 code, e.g. MXN)", s)
+	}
+	return c, nil
+}
@@ -0,0 +1,84 @@
+package i18n
+
+import (
+	"fmt"
+
+	"github.com/Vasenti/stori_challenge/internal/domain"
+)
+
+var catalogs = map[domain.Locale]map[string]string{
+	domain.LocaleEnUS: {
+		"subject":            "Your transaction report - %s",
+		"title":              "Account Summary",
+		"badge":              "Summary %s",
+		"heading":            "Account summary",
+		"generated":          "Generated",
+		"balance_total":      "Total balance",
+		"avg_debit":          "Debit average",
+		"avg_credit":         "Credit average",
+		"monthly_heading":    "Monthly transactions",
+		"month":              "Month",
+		"transactions_count": "# Transactions",
+		"no_transactions":    "No transactions",
+		"tip_missing_month":  "Tip: if you can not see a month, it means there were no transactions in that month.",
+		"footer":             "This report was generated automatically by Vasenti.",
+		"transactions":       "Transactions",
+		"date":               "Date",
+		"amount":             "Amount",
+		"and_more":           "and %d more",
+		"top_debits":         "Largest debits",
+		"top_credits":        "Largest credits",
+		"credits":            "Credits",
+		"debits":             "Debits",
+		"charts_heading":     "Activity",
+		"chart_flow":         "Credits vs debits by month",
+		"chart_balance":      "Running balance",
+		"accounts_heading":   "By account",
+		"account":            "Account",
+	},
+	domain.LocaleEsMX: {
+		"subject":            "Tu reporte de transacciones - %s",
+		"title":              "Resumen de cuenta",
+		"badge":              "Resumen %s",
+		"heading":            "Resumen de cuenta",
+		"generated":          "Generado",
+		"balance_total":      "Saldo total",
+		"avg_debit":          "Débito promedio",
+		"avg_credit":         "Crédito promedio",
+		"monthly_heading":    "Transacciones por mes",
+		"month":              "Mes",
+		"transactions_count": "# Transacciones",
+		"no_transactions":    "No hay transacciones",
+		"tip_missing_month":  "Tip: si no ves un mes, significa que no hubo transacciones en ese mes.",
+		"footer":             "Este reporte fue generado automáticamente por Vasenti.",
+		"transactions":       "Transacciones",
+		"date":               "Fecha",
+		"amount":             "Monto",
+		"and_more":           "y %d más",
+		"top_debits":         "Mayores débitos",
+		"top_credits":        "Mayores créditos",
+		"credits":            "Créditos",
+		"debits":             "Débitos",
+		"charts_heading":     "Actividad",
+		"chart_flow":         "Créditos vs débitos por mes",
+		"chart_balance":      "Saldo acumulado",
+		"accounts_heading":   "Por cuenta",
+		"account":            "Cuenta",
+	},
+}
+
+// T returns the message for key in locale, formatted with args. Missing
+// translations fall back to DefaultLocale and then to the key itself.
+func T(locale domain.Locale, key string, args ...any) string {
+	msg, ok := catalogs[locale][key]
+	if !ok {
+		msg, ok = catalogs[domain.DefaultLocale][key]
+	}
+	if !ok {
+		return key
+	}
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
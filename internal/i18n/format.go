@@ -0,0 +1,103 @@
+package i18n
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Vasenti/stori_challenge/internal/domain"
+)
+
+type numberFormat struct {
+	decimal string
+	group   string
+	// symbol stands for the locale's home currency; other currencies are
+	// written with their ISO 4217 code.
+	symbol string
+	home   string
+}
+
+var numberFormats = map[domain.Locale]numberFormat{
+	domain.LocaleEnUS: {decimal: ".", group: ",", symbol: "$", home: "USD"},
+	domain.LocaleEsMX: {decimal: ".", group: ",", symbol: "$", home: "MXN"},
+}
+
+var monthNames = map[domain.Locale][12]string{
+	domain.LocaleEsMX: {
+		"enero", "febrero", "marzo", "abril", "mayo", "junio",
+		"julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre",
+	},
+}
+
+func formatFor(locale domain.Locale) numberFormat {
+	if nf, ok := numberFormats[locale]; ok {
+		return nf
+	}
+	return numberFormats[domain.DefaultLocale]
+}
+
+// Number formats v with two decimals and locale grouping, e.g. 1,234.50.
+func Number(locale domain.Locale, v float64) string {
+	nf := formatFor(locale)
+	s := strconv.FormatFloat(math.Abs(v), 'f', 2, 64)
+	intPart, frac, _ := strings.Cut(s, ".")
+
+	var b strings.Builder
+	if v < 0 && s != "0.00" {
+		b.WriteByte('-')
+	}
+	for i, d := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			b.WriteString(nf.group)
+		}
+		b.WriteRune(d)
+	}
+	b.WriteString(nf.decimal)
+	b.WriteString(frac)
+	return b.String()
+}
+
+// Money formats v in currency (an ISO 4217 code), e.g. $1,234.50 or -$10.30
+// in the locale's own currency and MXN 1,234.50 in any other. Empty currency
+// is the locale's.
+func Money(locale domain.Locale, currency string, v float64) string {
+	nf := formatFor(locale)
+	prefix := nf.symbol
+	if currency != "" && currency != nf.home {
+		prefix = currency + " "
+	}
+	n := Number(locale, v)
+	if after, ok := strings.CutPrefix(n, "-"); ok {
+		return "-" + prefix + after
+	}
+	return prefix + n
+}
+
+func MonthName(locale domain.Locale, m time.Month) string {
+	if names, ok := monthNames[locale]; ok && m >= time.January && m <= time.December {
+		return names[m-1]
+	}
+	return m.String()
+}
+
+// MonthYear formats t as "September 2025" / "septiembre 2025".
+func MonthYear(locale domain.Locale, t time.Time) string {
+	return MonthName(locale, t.Month()) + " " + strconv.Itoa(t.Year())
+}
+
+// Date formats t as a long date, e.g. "September 5, 2025" / "5 de septiembre de 2025".
+func Date(locale domain.Locale, t time.Time) string {
+	if locale == domain.LocaleEsMX {
+		return strconv.Itoa(t.Day()) + " de " + MonthName(locale, t.Month()) + " de " + strconv.Itoa(t.Year())
+	}
+	return t.Format("January 2, 2006")
+}
+
+// DateTime formats t as a short numeric date and time in the locale's order.
+func DateTime(locale domain.Locale, t time.Time) string {
+	if locale == domain.LocaleEsMX {
+		return t.Format("02/01/2006 15:04")
+	}
+	return t.Format("01/02/2006 3:04 PM")
+}
@@ -0,0 +1,136 @@
+package batch
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/Vasenti/stori_challenge/internal/application/ports"
+	"github.com/Vasenti/stori_challenge/internal/domain"
+)
+
+// manifestEntry is one manifest row; CSV manifests use the json names as
+// header columns.
+type manifestEntry struct {
+	Email      string `json:"email"`
+	Source     string `json:"source"`
+	Account    string `json:"account"`
+	Template   string `json:"template"`
+	Locale     string `json:"locale"`
+	Channel    string `json:"channel"`
+	WebhookURL string `json:"webhook_url"`
+}
+
+// FormatOf returns "json" for .json manifests and "csv" for anything else.
+func FormatOf(name string) string {
+	if strings.EqualFold(path.Ext(name), ".json") {
+		return "json"
+	}
+	return "csv"
+}
+
+// ReadManifest reads a CSV (with header) or JSON array manifest. email and
+// source are required; account names the account the source is imported
+// into, and the other columns are optional per-user overrides.
+func ReadManifest(r io.Reader, format string) ([]ports.BatchJob, error) {
+	var entries []manifestEntry
+	var err error
+	if format == "json" {
+		err = json.NewDecoder(r).Decode(&entries)
+	} else {
+		entries, err = readCSVManifest(r)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+
+	jobs := make([]ports.BatchJob, 0, len(entries))
+	for i, e := range entries {
+		e.Email, e.Source = strings.TrimSpace(e.Email), strings.TrimSpace(e.Source)
+		if e.Email == "" || e.Source == "" {
+			return nil, fmt.Errorf("manifest entry %d: email and source are required", i+1)
+		}
+		ch, err := domain.ParseChannel(e.Channel)
+		if err != nil {
+			return nil, fmt.Errorf("manifest entry %d: %w", i+1, err)
+		}
+		jobs = append(jobs, ports.BatchJob{
+			Email:   e.Email,
+			Sources: []ports.BatchSource{{Path: e.Source, Account: e.Account}},
+			Options: ports.ReportOptions{
+				Template:   e.Template,
+				Locale:     e.Locale,
+				Channel:    ch,
+				WebhookURL: e.WebhookURL,
+			},
+		})
+	}
+	return jobs, nil
+}
+
+func readCSVManifest(r io.Reader) ([]manifestEntry, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	col := map[string]int{}
+	for i, h := range rows[0] {
+		col[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	if _, ok := col["email"]; !ok {
+		return nil, fmt.Errorf("missing email column")
+	}
+	if _, ok := col["source"]; !ok {
+		return nil, fmt.Errorf("missing source column")
+	}
+	get := func(row []string, name string) string {
+		if i, ok := col[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	entries := make([]manifestEntry, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		entries = append(entries, manifestEntry{
+			Email:      get(row, "email"),
+			Source:     get(row, "source"),
+			Account:    get(row, "account"),
+			Template:   get(row, "template"),
+			Locale:     get(row, "locale"),
+			Channel:    get(row, "channel"),
+			WebhookURL: get(row, "webhook_url"),
+		})
+	}
+	return entries, nil
+}
+
+// JobsFromPrefix builds jobs from the s3://bucket/<prefix>/<email>/*.csv
+// convention: uris are the objects listed under prefix, and the first path
+// segment after it is the user's email.
+func JobsFromPrefix(prefix string, uris []string) []ports.BatchJob {
+	prefix = strings.TrimSuffix(prefix, "/") + "/"
+	var jobs []ports.BatchJob
+	for _, uri := range uris {
+		rest, ok := strings.CutPrefix(uri, prefix)
+		if !ok || !strings.EqualFold(path.Ext(rest), ".csv") {
+			continue
+		}
+		email, _, ok := strings.Cut(rest, "/")
+		if !ok || email == "" {
+			continue
+		}
+		jobs = append(jobs, ports.BatchJob{Email: email, Sources: []ports.BatchSource{{Path: uri}}})
+	}
+	return jobs
+}
@@ -0,0 +1,49 @@
+package batch
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/Vasenti/stori_challenge/internal/application/ports"
+	"github.com/Vasenti/stori_challenge/internal/domain"
+)
+
+type summaryRow struct {
+	Email      string   `json:"email"`
+	Sources    []string `json:"sources"`
+	Status     string   `json:"status"`
+	Code       string   `json:"code,omitempty"`
+	Error      string   `json:"error,omitempty"`
+	DurationMS int64    `json:"duration_ms"`
+}
+
+// WriteSummary writes one row per user with its status ("ok" or "failed")
+// and, for failures, the error code (see domain.Code) and message, as CSV
+// or, when format is "json", as a JSON array.
+func WriteSummary(w io.Writer, format string, results []ports.BatchResult) error {
+	rows := make([]summaryRow, 0, len(results))
+	for _, r := range results {
+		row := summaryRow{Email: r.Email, Sources: r.Sources, Status: "ok", DurationMS: r.Duration.Milliseconds()}
+		if r.Err != nil {
+			row.Status, row.Code, row.Error = "failed", domain.Code(r.Err), r.Err.Error()
+		}
+		rows = append(rows, row)
+	}
+
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	}
+
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"email", "sources", "status", "code", "error", "duration_ms"})
+	for _, r := range rows {
+		_ = cw.Write([]string{r.Email, strings.Join(r.Sources, ";"), r.Status, r.Code, r.Error, strconv.FormatInt(r.DurationMS, 10)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
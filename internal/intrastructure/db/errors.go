@@ -0,0 +1,55 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/Vasenti/stori_challenge/internal/domain"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// classify is a GORM plugin tagging statement errors caused by a lost or
+// unreachable server with domain.ErrDBUnavailable.
+type classify struct{}
+
+func (classify) Name() string { return "classify" }
+
+func (classify) Initialize(db *gorm.DB) error {
+	tag := func(tx *gorm.DB) { tx.Error = unavailable(tx.Error) }
+	cb := db.Callback()
+	return errors.Join(
+		cb.Create().After("gorm:create").Register("classify:after_create", tag),
+		cb.Query().After("gorm:query").Register("classify:after_query", tag),
+		cb.Update().After("gorm:update").Register("classify:after_update", tag),
+		cb.Delete().After("gorm:delete").Register("classify:after_delete", tag),
+		cb.Row().After("gorm:row").Register("classify:after_row", tag),
+		cb.Raw().After("gorm:raw").Register("classify:after_raw", tag),
+	)
+}
+
+// unavailable wraps err with domain.ErrDBUnavailable when it is a connection
+// failure; other errors (constraints, bad SQL, ...) are returned as is.
+func unavailable(err error) error {
+	if err == nil || errors.Is(err, domain.ErrDBUnavailable) || !isConnError(err) {
+		return err
+	}
+	return domain.Wrap("", domain.ErrDBUnavailable, err)
+}
+
+func isConnError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var connErr *pgconn.ConnectError
+	var netErr net.Error
+	if errors.As(err, &connErr) || errors.As(err, &netErr) {
+		return true
+	}
+	// SQLSTATE class 08 (connection exception) and 57P0x (server shutting down)
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && (strings.HasPrefix(pgErr.Code, "08") || strings.HasPrefix(pgErr.Code, "57P0"))
+}
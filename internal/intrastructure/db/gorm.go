@@ -2,25 +2,32 @@ package db
 
 import (
 	"fmt"
+	"log/slog"
 	"time"
 
 	"github.com/Vasenti/stori_challenge/internal/config"
 	"github.com/Vasenti/stori_challenge/internal/domain"
+	"github.com/Vasenti/stori_challenge/internal/logging"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 )
 
-func NewGorm(cfg *config.Config) (*gorm.DB, error) {
+func NewGorm(cfg *config.Config, log *slog.Logger) (*gorm.DB, error) {
 	dsn := fmt.Sprintf(
 		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
 		cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.DBName, cfg.DBSSLMode,
 	)
 
-	gormLogger := logger.Default.LogMode(logger.Warn)
-	
+	gormLogger := logging.Gorm(log, time.Duration(cfg.DBSlowQueryMillis)*time.Millisecond)
+
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: gormLogger})
 	if err != nil {
+		return nil, domain.Wrap("connect", domain.ErrDBUnavailable, err)
+	}
+	if err := db.Use(tracing{}); err != nil {
+		return nil, err
+	}
+	if err := db.Use(classify{}); err != nil {
 		return nil, err
 	}
 
@@ -32,9 +39,18 @@ func NewGorm(cfg *config.Config) (*gorm.DB, error) {
 	sqlDB.SetMaxIdleConns(cfg.DBMaxIdle)
 	sqlDB.SetConnMaxLifetime(time.Duration(cfg.DBMaxLifetimeSecs) * time.Second)
 
-	if err := db.AutoMigrate(&domain.User{}, &domain.Transaction{}); err != nil {
-		return nil, err
+	if err := migrateUserIDs(db); err != nil {
+		return nil, domain.Wrap("migrate user ids", nil, unavailable(err))
+	}
+	if err := db.AutoMigrate(&domain.User{}, &domain.Account{}); err != nil {
+		return nil, domain.Wrap("migrate", nil, unavailable(err))
+	}
+	if err := migrateAccounts(db); err != nil {
+		return nil, domain.Wrap("migrate accounts", nil, unavailable(err))
+	}
+	if err := db.AutoMigrate(&domain.Transaction{}, &domain.OutboxMessage{}, &domain.ReportRun{}); err != nil {
+		return nil, domain.Wrap("migrate", nil, unavailable(err))
 	}
 
 	return db, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,79 @@
+package db
+
+import "gorm.io/gorm"
+
+// userIDSteps moves a database created when users were keyed by email to
+// UUID user ids: users get an id primary key and transactions reference it
+// instead of user_email. gen_random_uuid needs Postgres 13+.
+var userIDSteps = []string{
+	`ALTER TABLE users ADD COLUMN id uuid`,
+	`UPDATE users SET id = gen_random_uuid()`,
+	`ALTER TABLE users ALTER COLUMN id SET NOT NULL`,
+	`ALTER TABLE transactions ADD COLUMN user_id uuid`,
+	`UPDATE transactions t SET user_id = u.id FROM users u WHERE u.email = t.user_email`,
+	`ALTER TABLE transactions DROP CONSTRAINT IF EXISTS fk_users_transactions`,
+	`ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_pkey`,
+	`ALTER TABLE transactions DROP COLUMN user_email`,
+	`ALTER TABLE transactions ALTER COLUMN user_id SET NOT NULL`,
+	`ALTER TABLE transactions ADD PRIMARY KEY (user_id, id)`,
+	`ALTER TABLE users DROP CONSTRAINT users_pkey`,
+	`ALTER TABLE users ADD PRIMARY KEY (id)`,
+	`ALTER TABLE outbox ADD COLUMN IF NOT EXISTS user_id uuid`,
+	`UPDATE outbox o SET user_id = u.id FROM users u WHERE u.email = o.recipient`,
+}
+
+// migrateUserIDs runs userIDSteps in one transaction when the users table
+// still lacks its id column; AutoMigrate then adds the remaining columns,
+// indexes and the transactions foreign key.
+func migrateUserIDs(db *gorm.DB) error {
+	m := db.Migrator()
+	if !m.HasTable("users") || m.HasColumn("users", "id") {
+		return nil
+	}
+	steps := userIDSteps
+	if !m.HasTable("outbox") {
+		steps = steps[:len(steps)-2]
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, stmt := range steps {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// accountSteps moves transactions keyed by user to accounts: every user with
+// transactions gets a "main" debit account, which takes them over.
+var accountSteps = []string{
+	`INSERT INTO accounts (id, user_id, name, type, currency, created_at)
+		SELECT gen_random_uuid(), u.id, 'main', 'debit', u.currency, now() FROM users u
+		WHERE EXISTS (SELECT 1 FROM transactions t WHERE t.user_id = u.id)
+		ON CONFLICT DO NOTHING`,
+	`ALTER TABLE transactions ADD COLUMN account_id uuid`,
+	`UPDATE transactions t SET account_id = a.id FROM accounts a WHERE a.user_id = t.user_id AND a.name = 'main'`,
+	`ALTER TABLE transactions DROP CONSTRAINT IF EXISTS fk_users_transactions`,
+	`ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_pkey`,
+	`ALTER TABLE transactions DROP COLUMN user_id`,
+	`ALTER TABLE transactions ALTER COLUMN account_id SET NOT NULL`,
+	`ALTER TABLE transactions ADD PRIMARY KEY (account_id, id)`,
+}
+
+// migrateAccounts runs accountSteps in one transaction when the transactions
+// table still has no account_id column. It needs the accounts table, so it
+// runs after users and accounts are auto-migrated.
+func migrateAccounts(db *gorm.DB) error {
+	m := db.Migrator()
+	if !m.HasTable("transactions") || m.HasColumn("transactions", "account_id") {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, stmt := range accountSteps {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,35 @@
+package reader
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/Vasenti/stori_challenge/internal/application/ports"
+)
+
+// AutoReader opens s3:// paths with an S3 reader, created on first use, and
+// everything else from the local file system.
+type AutoReader struct {
+	newS3 func() (ports.Reader, error)
+
+	once  sync.Once
+	s3    ports.Reader
+	s3Err error
+}
+
+func NewAutoReader(newS3 func() (ports.Reader, error)) *AutoReader {
+	return &AutoReader{newS3: newS3}
+}
+
+func (r *AutoReader) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(path, "s3://") {
+		return LocalFileReader{}.Open(ctx, path)
+	}
+	r.once.Do(func() { r.s3, r.s3Err = r.newS3() })
+	if r.s3Err != nil {
+		return nil, r.s3Err
+	}
+	return r.s3.Open(ctx, path)
+}
@@ -1,16 +1,28 @@
 package reader
 
 import (
+	"context"
+	"errors"
 	"io"
+	"io/fs"
 	"os"
 	"strings"
+
+	"github.com/Vasenti/stori_challenge/internal/domain"
 )
 
 type LocalFileReader struct{}
 
-func (LocalFileReader) Open(path string) (io.ReadCloser, error) {
+func (LocalFileReader) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	if after, ok := strings.CutPrefix(path, "file://"); ok  {
 		path = after
 	}
-	return os.Open(path)
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, domain.Wrap("", domain.ErrSourceNotFound, err)
+	}
+	return f, err
 }
\ No newline at end of file
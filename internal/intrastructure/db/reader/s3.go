@@ -3,16 +3,23 @@ package reader
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"net/http"
 	"net/url"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	awsCfg "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/Vasenti/stori_challenge/internal/domain"
 )
 
 type S3Reader struct {
@@ -56,7 +63,7 @@ func NewS3Reader(region, endpoint, accessKey, secretKey string, forcePathStyle b
 	return &S3Reader{cfg: cfg}, nil
 }
 
-func (s *S3Reader) Open(s3url string) (io.ReadCloser, error) {
+func (s *S3Reader) Open(ctx context.Context, s3url string) (io.ReadCloser, error) {
 	if !strings.HasPrefix(s3url, "s3://") {
 		return nil, fmt.Errorf("ruta no es s3://")
 	}
@@ -73,12 +80,81 @@ func (s *S3Reader) Open(s3url string) (io.ReadCloser, error) {
 
 	buf := manager.NewWriteAtBuffer(nil)
 	d := manager.NewDownloader(client)
-	_, err = d.Download(context.Background(), buf, &s3.GetObjectInput{
+	_, err = d.Download(ctx, buf, &s3.GetObjectInput{
 		Bucket: &bucket,
 		Key:    &key,
 	})
 	if err != nil {
+		var nsk *types.NoSuchKey
+		var re *awshttp.ResponseError
+		if errors.As(err, &nsk) || (errors.As(err, &re) && re.HTTPStatusCode() == http.StatusNotFound) {
+			return nil, domain.Errorf(domain.ErrSourceNotFound, "%s: %w", s3url, fs.ErrNotExist)
+		}
 		return nil, err
 	}
 	return io.NopCloser(bytes.NewReader(buf.Bytes())), nil
 }
+
+// List returns the s3:// URIs of every object under the prefix URI.
+func (s *S3Reader) List(ctx context.Context, prefixURL string) ([]string, error) {
+	if !strings.HasPrefix(prefixURL, "s3://") {
+		return nil, fmt.Errorf("ruta no es s3://")
+	}
+	u, err := url.Parse(prefixURL)
+	if err != nil {
+		return nil, err
+	}
+	bucket := u.Host
+	prefix := strings.TrimPrefix(u.Path, "/")
+
+	client := s3.NewFromConfig(s.cfg, func(o *s3.Options) {
+		o.UsePathStyle = true
+	})
+
+	var uris []string
+	p := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{Bucket: &bucket, Prefix: &prefix})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			uris = append(uris, "s3://"+bucket+"/"+aws.ToString(obj.Key))
+		}
+	}
+	return uris, nil
+}
+
+// Attributes returns the user metadata (without the x-amz-meta- prefix,
+// lower-cased) and the tags of an object.
+func (s *S3Reader) Attributes(ctx context.Context, s3url string) (metadata, tags map[string]string, err error) {
+	u, err := url.Parse(s3url)
+	if err != nil || u.Scheme != "s3" {
+		return nil, nil, fmt.Errorf("ruta no es s3://")
+	}
+	bucket := u.Host
+	key := strings.TrimPrefix(u.Path, "/")
+
+	client := s3.NewFromConfig(s.cfg, func(o *s3.Options) {
+		o.UsePathStyle = true
+	})
+
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &bucket, Key: &key})
+	if err != nil {
+		return nil, nil, err
+	}
+	metadata = make(map[string]string, len(head.Metadata))
+	for k, v := range head.Metadata {
+		metadata[strings.ToLower(k)] = v
+	}
+
+	tagging, err := client.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{Bucket: &bucket, Key: &key})
+	if err != nil {
+		return metadata, nil, err
+	}
+	tags = make(map[string]string, len(tagging.TagSet))
+	for _, t := range tagging.TagSet {
+		tags[aws.ToString(t.Key)] = aws.ToString(t.Value)
+	}
+	return metadata, tags, nil
+}
@@ -0,0 +1,56 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Vasenti/stori_challenge/internal/application/ports"
+	"github.com/Vasenti/stori_challenge/internal/domain"
+	"github.com/Vasenti/stori_challenge/internal/intrastructure/db"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type accountRepo struct{ db *gorm.DB }
+
+func NewAccountRepository(db *gorm.DB) ports.AccountRepository {
+	return &accountRepo{db: db}
+}
+
+func (r *accountRepo) Default(ctx context.Context, userID, currency string) (domain.Account, error) {
+	var a domain.Account
+	err := db.Conn(ctx, r.db).
+		Where(domain.Account{UserID: userID, Name: domain.DefaultAccountName}).
+		Attrs(domain.Account{ID: uuid.NewString(), Type: domain.AccountDebit, Currency: currency}).
+		FirstOrCreate(&a).Error
+	return a, err
+}
+
+func (r *accountRepo) GetByName(ctx context.Context, userID, name string) (domain.Account, error) {
+	var a domain.Account
+	err := db.Conn(ctx, r.db).Where("user_id = ? AND name = ?", userID, name).First(&a).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return a, domain.Errorf(domain.ErrValidation, "unknown account %q", name)
+	}
+	return a, err
+}
+
+func (r *accountRepo) Create(ctx context.Context, a *domain.Account) error {
+	return db.Conn(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		var n int64
+		if err := tx.Model(&domain.Account{}).Where("user_id = ? AND name = ?", a.UserID, a.Name).Count(&n).Error; err != nil {
+			return err
+		}
+		if n > 0 {
+			return domain.Errorf(domain.ErrValidation, "account %q already exists", a.Name)
+		}
+		a.ID = uuid.NewString()
+		return tx.Create(a).Error
+	})
+}
+
+func (r *accountRepo) List(ctx context.Context, userID string) ([]domain.Account, error) {
+	var accounts []domain.Account
+	err := db.Conn(ctx, r.db).Where("user_id = ?", userID).Order("name").Find(&accounts).Error
+	return accounts, err
+}
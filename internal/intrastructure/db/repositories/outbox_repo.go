@@ -0,0 +1,80 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/Vasenti/stori_challenge/internal/application/ports"
+	"github.com/Vasenti/stori_challenge/internal/domain"
+	"github.com/Vasenti/stori_challenge/internal/intrastructure/db"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type outboxRepo struct{ db *gorm.DB }
+
+func NewOutboxRepository(db *gorm.DB) ports.OutboxRepository {
+	return &outboxRepo{db: db}
+}
+
+func (r *outboxRepo) Enqueue(ctx context.Context, msg *domain.OutboxMessage) error {
+	if msg.Status == "" {
+		msg.Status = domain.OutboxPending
+	}
+	if msg.NextAttemptAt.IsZero() {
+		msg.NextAttemptAt = time.Now()
+	}
+	return db.Conn(ctx, r.db).Create(msg).Error
+}
+
+func (r *outboxRepo) ClaimDue(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]domain.OutboxMessage, error) {
+	var msgs []domain.OutboxMessage
+	err := db.Conn(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		if err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", domain.OutboxPending, now).
+			Order("next_attempt_at").
+			Limit(limit).
+			Find(&msgs).Error; err != nil {
+			return err
+		}
+		if len(msgs) == 0 {
+			return nil
+		}
+		ids := make([]uint, 0, len(msgs))
+		for _, m := range msgs {
+			ids = append(ids, m.ID)
+		}
+		return tx.Model(&domain.OutboxMessage{}).
+			Where("id IN ?", ids).
+			Update("next_attempt_at", now.Add(lease)).Error
+	})
+	return msgs, err
+}
+
+func (r *outboxRepo) MarkSent(ctx context.Context, id uint, at time.Time) error {
+	return db.Conn(ctx, r.db).
+		Model(&domain.OutboxMessage{}).
+		Where("id = ?", id).
+		Updates(map[string]any{
+			"status":     domain.OutboxSent,
+			"sent_at":    at,
+			"last_error": "",
+		}).Error
+}
+
+func (r *outboxRepo) MarkFailed(ctx context.Context, id uint, attempts int, nextAttemptAt time.Time, lastErr string, dead bool) error {
+	status := domain.OutboxPending
+	if dead {
+		status = domain.OutboxDead
+	}
+	return db.Conn(ctx, r.db).
+		Model(&domain.OutboxMessage{}).
+		Where("id = ?", id).
+		Updates(map[string]any{
+			"status":          status,
+			"attempts":        attempts,
+			"next_attempt_at": nextAttemptAt,
+			"last_error":      lastErr,
+		}).Error
+}
@@ -0,0 +1,26 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/Vasenti/stori_challenge/internal/application/ports"
+	"github.com/Vasenti/stori_challenge/internal/domain"
+	"github.com/Vasenti/stori_challenge/internal/intrastructure/db"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type reportRunRepo struct{ db *gorm.DB }
+
+func NewReportRunRepository(db *gorm.DB) ports.ReportRunRepository {
+	return &reportRunRepo{db: db}
+}
+
+// Claim inserts the run, doing nothing if it exists. Inside the report's
+// transaction the claim rolls back with it if queueing fails.
+func (r *reportRunRepo) Claim(ctx context.Context, userID, period string) (bool, error) {
+	res := db.Conn(ctx, r.db).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&domain.ReportRun{UserID: userID, Period: period})
+	return res.RowsAffected == 1, res.Error
+}
@@ -2,11 +2,10 @@ package repositories
 
 import (
 	"context"
-	"math"
-	"time"
 
 	"github.com/Vasenti/stori_challenge/internal/application/ports"
 	"github.com/Vasenti/stori_challenge/internal/domain"
+	"github.com/Vasenti/stori_challenge/internal/intrastructure/db"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
@@ -17,62 +16,163 @@ func NewTransactionRepository(db *gorm.DB) ports.TransactionRepository {
 	return &transactionRepo{db: db}
 }
 
-func (r *transactionRepo) BulkUpsert(ctx context.Context, txs []domain.Transaction) error {
+// BulkUpsert inserts txs, skipping ones already stored, and returns how many were inserted.
+func (r *transactionRepo) BulkUpsert(ctx context.Context, txs []domain.Transaction) (int64, error) {
 	if len(txs) == 0 {
-		return nil
+		return 0, nil
 	}
-	return r.db.WithContext(ctx).
+	res := db.Conn(ctx, r.db).
 		Clauses(clause.OnConflict{
-			Columns:   []clause.Column{{Name: "user_email"}, {Name: "id"}},
+			Columns:   []clause.Column{{Name: "account_id"}, {Name: "id"}},
 			DoNothing: true,
 		}).
-		Create(&txs).Error
+		Create(&txs)
+	return res.RowsAffected, res.Error
 }
 
-func (r *transactionRepo) GetMonthlySummary(ctx context.Context, userEmail string) (domain.MonthlySummary, error) {
-	var txs []domain.Transaction
+// userAccounts selects the ids of a user's accounts, for account_id IN filters.
+func userAccounts(tx *gorm.DB, userID string) *gorm.DB {
+	return tx.Model(&domain.Account{}).Select("id").Where("user_id = ?", userID)
+}
 
-	if err := r.db.WithContext(ctx).
-		Select("occurred_at", "amount").
-		Where("user_email = ?", userEmail).
-		Find(&txs).Error; err != nil {
-		return domain.MonthlySummary{}, err
+// inPeriod scopes a transactions query to period; the zero period is all time.
+func inPeriod(period domain.Period) func(*gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		if period.IsZero() {
+			return tx
+		}
+		return tx.Where("occurred_at >= ? AND occurred_at < ?", period.From, period.To)
 	}
+}
+
+// GetMonthlySummary consolidates every account of the user over period and
+// breaks the totals down per account. The opening balance covers the same
+// accounts before period. Amounts in different currencies are never added
+// up: a user whose accounts have different currencies is a validation error.
+func (r *transactionRepo) GetMonthlySummary(ctx context.Context, userID string, period domain.Period) (domain.MonthlySummary, error) {
+	conn := db.Conn(ctx, r.db)
 
-	trxByMonth := make(map[time.Month]int, 12)
+	var accounts []domain.Account
+	if err := conn.Where("user_id = ?", userID).Order("name").Find(&accounts).Error; err != nil {
+		return domain.MonthlySummary{}, err
+	}
+	currency, err := commonCurrency(accounts)
+	if err != nil {
+		return domain.MonthlySummary{}, err
+	}
 
-	var balance float64
-	var sumCredits float64
-	var cntCredits int
-	var sumDebitsAbs float64
-	var cntDebits int
+	var txs []domain.Transaction
+	if err := conn.
+		Select("account_id", "occurred_at", "amount").
+		Where("account_id IN (?)", userAccounts(conn, userID)).
+		Scopes(inPeriod(period)).
+		Find(&txs).Error; err != nil {
+		return domain.MonthlySummary{}, err
+	}
 
+	byAccount := make(map[string][]domain.Transaction, len(accounts))
 	for _, t := range txs {
-		balance += t.Amount
+		byAccount[t.AccountID] = append(byAccount[t.AccountID], t)
+	}
 
-		trxByMonth[t.OccurredAt.Month()]++
+	sum := domain.Summarize(txs)
+	sum.Period = period
+	sum.Currency = currency
+	if !period.IsZero() {
+		if err := conn.Model(&domain.Transaction{}).
+			Select("COALESCE(SUM(amount), 0)").
+			Where("account_id IN (?)", userAccounts(conn, userID)).
+			Where("occurred_at < ?", period.From).
+			Scan(&sum.OpeningBalance).Error; err != nil {
+			return domain.MonthlySummary{}, err
+		}
+	}
+	sum.Accounts = make([]domain.AccountSummary, 0, len(accounts))
+	for _, a := range accounts {
+		s := domain.Summarize(byAccount[a.ID])
+		sum.Accounts = append(sum.Accounts, domain.AccountSummary{
+			Account:      a,
+			BalanceTotal: s.BalanceTotal,
+			AvgDebit:     s.AvgDebit,
+			AvgCredit:    s.AvgCredit,
+			Transactions: len(byAccount[a.ID]),
+		})
+	}
+	return sum, nil
+}
 
-		if t.Amount > 0 {
-			sumCredits += t.Amount
-			cntCredits++
-		} else if t.Amount < 0 {
-			sumDebitsAbs += math.Abs(t.Amount)
-			cntDebits++
+// commonCurrency is the currency shared by accounts; accounts without one
+// are taken to be in it. It fails when two accounts name different ones.
+func commonCurrency(accounts []domain.Account) (string, error) {
+	var currency string
+	for _, a := range accounts {
+		switch {
+		case a.Currency == "" || a.Currency == currency:
+		case currency == "":
+			currency = a.Currency
+		default:
+			return "", domain.Errorf(domain.ErrValidation, "cannot consolidate accounts in %s and %s", currency, a.Currency)
 		}
 	}
+	return currency, nil
+}
 
-	var avgCredit, avgDebit float64
-	if cntCredits > 0 {
-		avgCredit = sumCredits / float64(cntCredits)
+func (r *transactionRepo) List(ctx context.Context, userID string, period domain.Period) ([]domain.Transaction, error) {
+	var txs []domain.Transaction
+	conn := db.Conn(ctx, r.db)
+	err := conn.
+		Where("account_id IN (?)", userAccounts(conn, userID)).
+		Scopes(inPeriod(period)).
+		Order("occurred_at DESC, id DESC").
+		Find(&txs).Error
+	return txs, err
+}
+
+func (r *transactionRepo) Find(ctx context.Context, userID string, f ports.TransactionFilter) ([]domain.Transaction, int64, error) {
+	conn := db.Conn(ctx, r.db)
+	matching := func() *gorm.DB {
+		return conn.Model(&domain.Transaction{}).
+			Where("account_id IN (?)", userAccounts(conn, userID)).
+			Scopes(filtered(f))
+	}
+
+	var total int64
+	if err := matching().Count(&total).Error; err != nil {
+		return nil, 0, err
 	}
-	if cntDebits > 0 {
-		avgDebit = sumDebitsAbs / float64(cntDebits)
+	page := matching().Order("occurred_at DESC, id DESC")
+	if f.Limit > 0 {
+		page = page.Limit(f.Limit).Offset(f.Offset)
 	}
+	var txs []domain.Transaction
+	err := page.Find(&txs).Error
+	return txs, total, err
+}
 
-	return domain.MonthlySummary{
-		BalanceTotal:        balance,
-		TransactionsByMonth: trxByMonth,
-		AvgDebit:            avgDebit,
-		AvgCredit:           avgCredit,
-	}, nil
-}
\ No newline at end of file
+// filtered scopes a transactions query to f's conditions (not its page).
+func filtered(f ports.TransactionFilter) func(*gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		if f.AccountID != "" {
+			tx = tx.Where("account_id = ?", f.AccountID)
+		}
+		if !f.From.IsZero() {
+			tx = tx.Where("occurred_at >= ?", f.From)
+		}
+		if !f.To.IsZero() {
+			tx = tx.Where("occurred_at < ?", f.To)
+		}
+		if f.MinAmount != nil {
+			tx = tx.Where("amount >= ?", *f.MinAmount)
+		}
+		if f.MaxAmount != nil {
+			tx = tx.Where("amount <= ?", *f.MaxAmount)
+		}
+		switch f.Sign {
+		case domain.SignCredit:
+			tx = tx.Where("amount > 0")
+		case domain.SignDebit:
+			tx = tx.Where("amount < 0")
+		}
+		return tx
+	}
+}
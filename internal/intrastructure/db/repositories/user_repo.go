@@ -2,9 +2,12 @@ package repositories
 
 import (
 	"context"
+	"errors"
 
 	"github.com/Vasenti/stori_challenge/internal/application/ports"
 	"github.com/Vasenti/stori_challenge/internal/domain"
+	"github.com/Vasenti/stori_challenge/internal/intrastructure/db"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
@@ -14,6 +17,133 @@ func NewUserRepository(db *gorm.DB) ports.UserRepository {
 	return &userRepo{db: db}
 }
 
-func (r *userRepo) Ensure(ctx context.Context, email string) error {
-	return r.db.WithContext(ctx).FirstOrCreate(&domain.User{Email: email}).Error
-}
\ No newline at end of file
+func (r *userRepo) Ensure(ctx context.Context, email string) (domain.User, error) {
+	var u domain.User
+	err := db.Conn(ctx, r.db).
+		Where(domain.User{Email: email}).
+		Attrs(domain.User{ID: uuid.NewString(), ReportOptIn: true}).
+		FirstOrCreate(&u).Error
+	return u, err
+}
+
+func (r *userRepo) Get(ctx context.Context, id string) (domain.User, error) {
+	var u domain.User
+	err := db.Conn(ctx, r.db).Where("id = ?", id).First(&u).Error
+	return u, err
+}
+
+func (r *userRepo) GetByEmail(ctx context.Context, email string) (domain.User, error) {
+	var u domain.User
+	err := db.Conn(ctx, r.db).Where("email = ?", email).First(&u).Error
+	return u, err
+}
+
+func (r *userRepo) FindByEmail(ctx context.Context, email string) (domain.User, bool, error) {
+	u, err := r.GetByEmail(ctx, email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return domain.User{}, false, nil
+	}
+	return u, err == nil, err
+}
+
+func (r *userRepo) Update(ctx context.Context, u domain.User) error {
+	return db.Conn(ctx, r.db).
+		Model(&domain.User{ID: u.ID}).
+		Select("name", "locale", "timezone", "currency", "report_opt_in", "channel", "webhook_url",
+			"report_to", "report_cc", "report_bcc", "report_reply_to").
+		Updates(&u).Error
+}
+
+func (r *userRepo) SetReportOptIn(ctx context.Context, id string, optIn bool) error {
+	return db.Conn(ctx, r.db).Model(&domain.User{ID: id}).Update("report_opt_in", optIn).Error
+}
+
+func (r *userRepo) ChangeEmail(ctx context.Context, id, email string) error {
+	return db.Conn(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		var n int64
+		if err := tx.Model(&domain.User{}).Where("email = ? AND id <> ?", email, id).Count(&n).Error; err != nil {
+			return err
+		}
+		if n > 0 {
+			return domain.Errorf(domain.ErrValidation, "email %s is already used by another user", email)
+		}
+		res := tx.Model(&domain.User{ID: id}).Update("email", email)
+		if res.Error == nil && res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return res.Error
+	})
+}
+
+func (r *userRepo) ListOptedIn(ctx context.Context) ([]domain.User, error) {
+	var users []domain.User
+	err := db.Conn(ctx, r.db).Where("report_opt_in = ?", true).Order("email").Find(&users).Error
+	return users, err
+}
+
+func (r *userRepo) Emails(ctx context.Context) ([]string, error) {
+	var emails []string
+	err := db.Conn(ctx, r.db).Model(&domain.User{}).Order("email").Pluck("email", &emails).Error
+	return emails, err
+}
+
+// Merge renames from when into is free. Otherwise from's accounts move to
+// into; where into has an account of the same name, the transactions move
+// into it, except those it already has (same id), which are dropped.
+func (r *userRepo) Merge(ctx context.Context, from, into string) error {
+	return db.Conn(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		var src domain.User
+		if err := tx.Where("email = ?", from).First(&src).Error; err != nil {
+			return err
+		}
+		var dst domain.User
+		err := tx.Where("email = ?", into).First(&dst).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return tx.Model(&src).Update("email", into).Error
+		}
+		if err != nil {
+			return err
+		}
+
+		// Accounts into also has (by name) are folded into into's; the rest move over.
+		if err := tx.Exec(`INSERT INTO transactions (id, account_id, occurred_at, amount, raw_date, raw_amount)
+			SELECT t.id, d.id, t.occurred_at, t.amount, t.raw_date, t.raw_amount FROM transactions t
+			JOIN accounts s ON s.id = t.account_id AND s.user_id = ?
+			JOIN accounts d ON d.user_id = ? AND d.name = s.name
+			ON CONFLICT DO NOTHING`, src.ID, dst.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(`DELETE FROM transactions WHERE account_id IN (
+			SELECT s.id FROM accounts s JOIN accounts d ON d.user_id = ? AND d.name = s.name WHERE s.user_id = ?)`,
+			dst.ID, src.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(`DELETE FROM accounts s USING accounts d WHERE s.user_id = ? AND d.user_id = ? AND d.name = s.name`,
+			src.ID, dst.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&domain.Account{}).Where("user_id = ?", src.ID).Update("user_id", dst.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&domain.OutboxMessage{}).Where("user_id = ?", src.ID).
+			Updates(map[string]any{"user_id": dst.ID, "recipient": into}).Error; err != nil {
+			return err
+		}
+		// Months already reported to either user stay reported.
+		if err := tx.Exec(`INSERT INTO report_runs (user_id, period, created_at)
+			SELECT ?, period, created_at FROM report_runs WHERE user_id = ?
+			ON CONFLICT DO NOTHING`, dst.ID, src.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", src.ID).Delete(&domain.ReportRun{}).Error; err != nil {
+			return err
+		}
+		// An unsubscribe on either side survives the merge.
+		if !src.ReportOptIn && dst.ReportOptIn {
+			if err := tx.Model(&dst).Update("report_opt_in", false).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&src).Error
+	})
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/Vasenti/stori_challenge/internal/domain"
+	"github.com/Vasenti/stori_challenge/internal/telemetry"
+)
+
+// dryRun opens a DB with the tracing plugin that builds statements without
+// connecting, so their spans can be checked without a server.
+func dryRun(t *testing.T) *gorm.DB {
+	t.Helper()
+	gdb, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=127.0.0.1 port=1", PreferSimpleProtocol: true}), &gorm.Config{
+		DryRun:                 true,
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+		Logger:                 logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gdb.Use(tracing{}); err != nil {
+		t.Fatal(err)
+	}
+	return gdb
+}
+
+func TestTracingEmitsQuerySpans(t *testing.T) {
+	spans := tracetest.NewInMemoryExporter()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(spans)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	gdb := dryRun(t)
+	ctx, step := telemetry.StartStep(context.Background(), "upsert")
+	tx := domain.Transaction{ID: 1, AccountID: "acct-1", OccurredAt: time.Now(), Amount: 60.5, RawDate: "7/15", RawAmount: "+60.5"}
+	if err := gdb.WithContext(ctx).Create(&tx).Error; err != nil {
+		t.Fatal(err)
+	}
+	var users []domain.User
+	if err := gdb.WithContext(ctx).Where("email = ?", "ana@example.com").Find(&users).Error; err != nil {
+		t.Fatal(err)
+	}
+	step.End(nil)
+
+	got := spans.GetSpans()
+	var parent tracetest.SpanStub
+	for _, s := range got {
+		if s.Name == "report.upsert" {
+			parent = s
+		}
+	}
+	if !parent.SpanContext.IsValid() {
+		t.Fatalf("no report.upsert span in %d spans", len(got))
+	}
+	for _, want := range []struct{ name, table, statement string }{
+		{"gorm.create", "transactions", `INSERT INTO "transactions"`},
+		{"gorm.query", "users", `SELECT * FROM "users" WHERE email = `},
+	} {
+		t.Run(want.name, func(t *testing.T) {
+			var span *tracetest.SpanStub
+			for i := range got {
+				if got[i].Name == want.name {
+					span = &got[i]
+				}
+			}
+			if span == nil {
+				t.Fatalf("no %s span", want.name)
+			}
+			if span.Parent.SpanID() != parent.SpanContext.SpanID() {
+				t.Errorf("%s is not a child of the caller's span", want.name)
+			}
+			attrs := map[attribute.Key]string{}
+			for _, kv := range span.Attributes {
+				attrs[kv.Key] = kv.Value.Emit()
+			}
+			if attrs["db.system"] != "postgresql" || attrs["db.sql.table"] != want.table {
+				t.Errorf("attributes = %v, want postgresql table %q", attrs, want.table)
+			}
+			if !strings.HasPrefix(attrs["db.statement"], want.statement) {
+				t.Errorf("db.statement = %q, want it to start with %q", attrs["db.statement"], want.statement)
+			}
+		})
+	}
+}
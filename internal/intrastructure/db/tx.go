@@ -0,0 +1,41 @@
+package db
+
+import (
+	"context"
+
+	"github.com/Vasenti/stori_challenge/internal/application/ports"
+	"gorm.io/gorm"
+)
+
+type txKey struct{}
+
+type transactor struct{ db *gorm.DB }
+
+func NewTransactor(db *gorm.DB) ports.Transactor {
+	return &transactor{db: db}
+}
+
+func (t *transactor) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return fn(ctx)
+	}
+	// fn's own errors are already classified; only begin/commit failures are tagged here.
+	var fnErr error
+	err := t.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		fnErr = fn(context.WithValue(ctx, txKey{}, tx))
+		return fnErr
+	})
+	if err != nil && fnErr == nil {
+		return unavailable(err)
+	}
+	return err
+}
+
+// Conn returns the transaction bound to ctx by a Transactor, or fallback,
+// with ctx's deadline and span applied to its queries.
+func Conn(ctx context.Context, fallback *gorm.DB) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx.WithContext(ctx)
+	}
+	return fallback.WithContext(ctx)
+}
@@ -0,0 +1,39 @@
+package email
+
+import (
+	"context"
+	"maps"
+
+	"github.com/Vasenti/stori_challenge/internal/application/ports"
+	"github.com/Vasenti/stori_challenge/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+type emailNotifier struct {
+	sender         ports.EmailSender
+	unsubscribeURL func(userID string) string
+}
+
+// NewNotifier delivers notifications through an EmailSender. With
+// unsubscribeURL set, every report to a known user carries the RFC 8058
+// one-click List-Unsubscribe headers pointing at that user's link.
+func NewNotifier(sender ports.EmailSender, unsubscribeURL func(userID string) string) ports.Notifier {
+	return emailNotifier{sender: sender, unsubscribeURL: unsubscribeURL}
+}
+
+func (n emailNotifier) Notify(ctx context.Context, msg ports.Notification) (err error) {
+	ctx, span := telemetry.Start(ctx, "smtp.send",
+		attribute.Int("email.recipients", len(msg.Email.To)+len(msg.Email.Cc)+len(msg.Email.Bcc)))
+	defer func() { span.End(err) }()
+
+	if n.unsubscribeURL != nil && msg.UserID != "" {
+		headers := maps.Clone(msg.Email.Headers)
+		if headers == nil {
+			headers = map[string]string{}
+		}
+		headers["List-Unsubscribe"] = "<" + n.unsubscribeURL(msg.UserID) + ">"
+		headers["List-Unsubscribe-Post"] = "List-Unsubscribe=One-Click"
+		msg.Email.Headers = headers
+	}
+	return n.sender.Send(ctx, msg.Email)
+}
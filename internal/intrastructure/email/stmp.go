@@ -1,14 +1,28 @@
 package email
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
 	"net"
 	"net/smtp"
+	"net/textproto"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/Vasenti/stori_challenge/internal/application/ports"
 	"github.com/Vasenti/stori_challenge/internal/config"
+	"github.com/Vasenti/stori_challenge/internal/domain"
 )
 
 type SMTPSender struct {
@@ -19,48 +33,81 @@ func NewSMTPSender(cfg *config.Config) *SMTPSender {
 	return &SMTPSender{config: cfg}
 }
 
-func (s *SMTPSender) Send(to string, subject string, htmlBody string) error {
-
-	addr := net.JoinHostPort(s.config.SMTPHost, strconv.Itoa(s.config.SMTPPort))
-	toList := []string{to}
-
-	headers := map[string]string{
-		"From":         s.config.SMTPFrom,
-		"To":           to,
-		"Subject":      subject,
-		"MIME-Version": "1.0",
-		"Content-Type": "text/html; charset=\"UTF-8\"",
+// Send delivers m. ctx (bounded by SMTP_TIMEOUT_SECS) covers the whole SMTP
+// session; canceling it closes the connection. Permanent rejections of the
+// message (5xx replies other than authentication failures) are tagged
+// domain.ErrEmailRejected so they are not retried. A recipient other than the
+// first To address that the server refuses is logged and skipped; the send
+// fails only if the first To address, or every recipient, is refused.
+func (s *SMTPSender) Send(ctx context.Context, m ports.EmailMessage) error {
+	if s.config.SMTPTimeoutSecs > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(s.config.SMTPTimeoutSecs)*time.Second)
+		defer cancel()
 	}
-	var sb strings.Builder
-	for k, v := range headers {
-		sb.WriteString(fmt.Sprintf("%s: %s\r\n", k, v))
+	err := s.send(ctx, m)
+	if err != nil && ctx.Err() != nil {
+		// I/O errors caused by closing the connection hide the real cause.
+		return fmt.Errorf("smtp: %w", ctx.Err())
 	}
-	sb.WriteString("\r\n")
-	sb.WriteString(htmlBody)
+	var te *textproto.Error
+	if errors.As(err, &te) && te.Code >= 500 && !authReply(te.Code) {
+		return domain.Wrap("smtp", domain.ErrEmailRejected, err)
+	}
+	return err
+}
 
-	msg := []byte(sb.String())
+// authReply reports whether code is an SMTP authentication failure (RFC 4954),
+// which is a configuration problem rather than a rejected message.
+func authReply(code int) bool {
+	return code == 530 || code == 534 || code == 535 || code == 538
+}
 
-	// MailHog: sin auth
-	if s.config.SMTPUsername == "" && s.config.SMTPPassword == "" {
-		return smtp.SendMail(addr, nil, s.config.SMTPFrom, toList, msg)
+func (s *SMTPSender) send(ctx context.Context, m ports.EmailMessage) error {
+	addr := net.JoinHostPort(s.config.SMTPHost, strconv.Itoa(s.config.SMTPPort))
+	toList := make([]string, 0, len(m.To)+len(m.Cc)+len(m.Bcc))
+	toList = append(toList, m.To...)
+	toList = append(toList, m.Cc...)
+	toList = append(toList, m.Bcc...)
+	if len(toList) == 0 {
+		return domain.Errorf(domain.ErrValidation, "email has no recipients")
 	}
 
-	// Con AUTH (+ STARTTLS si el servidor lo soporta)
-	auth := smtp.PlainAuth("", s.config.SMTPUsername, s.config.SMTPPassword, s.config.SMTPHost)
-	tlsconfig := &tls.Config{ServerName: s.config.SMTPHost, InsecureSkipVerify: true}
+	msg, err := buildMessage(s.config.SMTPFrom, m)
+	if err != nil {
+		return err
+	}
 
-	c, err := smtp.Dial(addr)
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
 	if err != nil {
 		return err
 	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	c, err := smtp.NewClient(conn, s.config.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
 	defer c.Close()
 
+	// MailHog: sin auth. Con AUTH, STARTTLS (si el servidor lo soporta)
+	// acepta cualquier certificado.
+	withAuth := s.config.SMTPUsername != "" || s.config.SMTPPassword != ""
+	tlsconfig := &tls.Config{ServerName: s.config.SMTPHost, InsecureSkipVerify: withAuth}
+
 	if ok, _ := c.Extension("STARTTLS"); ok {
 		if err := c.StartTLS(tlsconfig); err != nil {
 			return err
 		}
 	}
-	if ok, _ := c.Extension("AUTH"); ok {
+	if ok, _ := c.Extension("AUTH"); ok && withAuth {
+		auth := smtp.PlainAuth("", s.config.SMTPUsername, s.config.SMTPPassword, s.config.SMTPHost)
 		if err := c.Auth(auth); err != nil {
 			return err
 		}
@@ -69,10 +116,23 @@ func (s *SMTPSender) Send(to string, subject string, htmlBody string) error {
 	if err := c.Mail(s.config.SMTPFrom); err != nil {
 		return err
 	}
-	for _, rcpt := range toList {
-		if err := c.Rcpt(rcpt); err != nil {
+	accepted := 0
+	var rejected error
+	for i, rcpt := range toList {
+		err := c.Rcpt(rcpt)
+		if err == nil {
+			accepted++
+			continue
+		}
+		var te *textproto.Error
+		if primary := i == 0 && len(m.To) > 0; primary || !errors.As(err, &te) {
 			return err
 		}
+		slog.WarnContext(ctx, "smtp recipient rejected; skipped", "recipient", rcpt, "error", err)
+		rejected = err
+	}
+	if accepted == 0 {
+		return rejected
 	}
 	w, err := c.Data()
 	if err != nil {
@@ -81,6 +141,114 @@ func (s *SMTPSender) Send(to string, subject string, htmlBody string) error {
 	if _, err := w.Write(msg); err != nil {
 		return err
 	}
-	return w.Close()
-	return nil
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// buildMessage renders the RFC 5322 message. Bcc addresses are only used as
+// envelope recipients and never written as a header. Messages with inline
+// assets are sent as multipart/related so the HTML can use cid: URLs.
+func buildMessage(from string, m ports.EmailMessage) ([]byte, error) {
+	headers := map[string]string{
+		"From":         from,
+		"Subject":      mime.QEncoding.Encode("UTF-8", m.Subject),
+		"MIME-Version": "1.0",
+		"Content-Type": "text/html; charset=\"UTF-8\"",
+	}
+	body := []byte(m.HTMLBody)
+	if len(m.Inline) > 0 {
+		var err error
+		var contentType string
+		body, contentType, err = buildRelatedBody(m.HTMLBody, m.Inline)
+		if err != nil {
+			return nil, err
+		}
+		headers["Content-Type"] = contentType
+	}
+	if len(m.To) > 0 {
+		headers["To"] = strings.Join(m.To, ", ")
+	}
+	if len(m.Cc) > 0 {
+		headers["Cc"] = strings.Join(m.Cc, ", ")
+	}
+	if m.ReplyTo != "" {
+		headers["Reply-To"] = m.ReplyTo
+	}
+	for k, v := range m.Headers {
+		k = textproto.CanonicalMIMEHeaderKey(k)
+		if _, reserved := headers[k]; reserved || k == "Bcc" {
+			continue
+		}
+		headers[k] = v
+	}
+
+	keys := make([]string, 0, len(headers))
+	for k, v := range headers {
+		if strings.ContainsAny(k+v, "\r\n") {
+			return nil, fmt.Errorf("invalid header %q: contains line break", k)
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, k := range keys {
+		sb.WriteString(fmt.Sprintf("%s: %s\r\n", k, headers[k]))
+	}
+	sb.WriteString("\r\n")
+	sb.Write(body)
+	return []byte(sb.String()), nil
+}
+
+// buildRelatedBody returns a multipart/related body with the HTML as root
+// part followed by one base64 part per inline asset, and its Content-Type.
+func buildRelatedBody(htmlBody string, inline []domain.EmailAsset) ([]byte, string, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	hp, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/html; charset=\"UTF-8\""},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	qp := quotedprintable.NewWriter(hp)
+	if _, err := qp.Write([]byte(htmlBody)); err != nil {
+		return nil, "", err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, "", err
+	}
+
+	for _, a := range inline {
+		if strings.ContainsAny(a.ContentID+a.ContentType+a.Filename, "\r\n\"<>") {
+			return nil, "", fmt.Errorf("invalid inline asset %q", a.Filename)
+		}
+		ap, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {a.ContentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Id":                {"<" + a.ContentID + ">"},
+			"Content-Disposition":       {fmt.Sprintf("inline; filename=\"%s\"", a.Filename)},
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		enc := base64.StdEncoding.EncodeToString(a.Data)
+		for len(enc) > 76 {
+			if _, err := io.WriteString(ap, enc[:76]+"\r\n"); err != nil {
+				return nil, "", err
+			}
+			enc = enc[76:]
+		}
+		if _, err := io.WriteString(ap, enc+"\r\n"); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), fmt.Sprintf("multipart/related; boundary=\"%s\"; type=\"text/html\"", mw.Boundary()), nil
 }
@@ -0,0 +1,160 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Vasenti/stori_challenge/internal/application/ports"
+	"github.com/Vasenti/stori_challenge/internal/config"
+	"github.com/Vasenti/stori_challenge/internal/domain"
+)
+
+// smtpServer is a minimal SMTP server that refuses RCPT TO for the
+// addresses in reject and records the recipients of delivered messages.
+type smtpServer struct {
+	reject map[string]bool
+
+	mu        sync.Mutex
+	delivered [][]string
+}
+
+func (s *smtpServer) serve(t *testing.T) *config.Config {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return &config.Config{SMTPHost: host, SMTPPort: p, SMTPFrom: "reports@example.com"}
+}
+
+func (s *smtpServer) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 test")
+	var rcpts []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimSpace(line)
+		verb, _, _ := strings.Cut(cmd, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			reply("250 test")
+		case "MAIL":
+			rcpts = nil
+			reply("250 ok")
+		case "RCPT":
+			addr := strings.Trim(cmd[strings.Index(cmd, ":")+1:], "<> ")
+			if s.reject[addr] {
+				reply("550 no such user")
+				continue
+			}
+			rcpts = append(rcpts, addr)
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+			}
+			s.mu.Lock()
+			s.delivered = append(s.delivered, rcpts)
+			s.mu.Unlock()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func TestSendSkipsRejectedExtraRecipients(t *testing.T) {
+	tests := []struct {
+		name      string
+		msg       ports.EmailMessage
+		reject    []string
+		rejected  bool
+		delivered []string
+	}{
+		{
+			name:      "all accepted",
+			msg:       ports.EmailMessage{To: []string{"owner@example.com", "partner@example.com"}, Cc: []string{"cc@example.com"}},
+			delivered: []string{"owner@example.com", "partner@example.com", "cc@example.com"},
+		},
+		{
+			name:      "extra to, cc and bcc refused",
+			msg:       ports.EmailMessage{To: []string{"owner@example.com", "gone@example.com"}, Cc: []string{"cc@example.com"}, Bcc: []string{"bcc@example.com"}},
+			reject:    []string{"gone@example.com", "bcc@example.com"},
+			delivered: []string{"owner@example.com", "cc@example.com"},
+		},
+		{
+			name:     "primary refused",
+			msg:      ports.EmailMessage{To: []string{"owner@example.com", "partner@example.com"}},
+			reject:   []string{"owner@example.com"},
+			rejected: true,
+		},
+		{
+			name:     "every recipient refused",
+			msg:      ports.EmailMessage{Cc: []string{"a@example.com"}, Bcc: []string{"b@example.com"}},
+			reject:   []string{"a@example.com", "b@example.com"},
+			rejected: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &smtpServer{reject: map[string]bool{}}
+			for _, a := range tt.reject {
+				srv.reject[a] = true
+			}
+			tt.msg.Subject, tt.msg.HTMLBody = "Report", "<p>hi</p>"
+
+			err := NewSMTPSender(srv.serve(t)).Send(context.Background(), tt.msg)
+			if tt.rejected {
+				if domain.Code(err) != "email_rejected" {
+					t.Fatalf("err = %v, want an email_rejected error", err)
+				}
+				srv.mu.Lock()
+				defer srv.mu.Unlock()
+				if len(srv.delivered) != 0 {
+					t.Fatalf("delivered %v, want nothing", srv.delivered)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv.mu.Lock()
+			defer srv.mu.Unlock()
+			if len(srv.delivered) != 1 || strings.Join(srv.delivered[0], ",") != strings.Join(tt.delivered, ",") {
+				t.Fatalf("delivered %v, want [%v]", srv.delivered, tt.delivered)
+			}
+		})
+	}
+}
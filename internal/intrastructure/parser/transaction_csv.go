@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"context"
 	"encoding/csv"
 	"fmt"
 	"io"
@@ -11,14 +12,29 @@ import (
 	"github.com/Vasenti/stori_challenge/internal/domain"
 )
 
-func ParseTransactionsCSV(r io.Reader, userEmail string, now time.Time) ([]domain.Transaction, error) {
+// checkEvery is how many rows are read between cancellation checks.
+const checkEvery = 1000
+
+func ParseTransactionsCSV(ctx context.Context, r io.Reader, accountID string, now time.Time) ([]domain.Transaction, error) {
 	cr := csv.NewReader(r)
 	cr.TrimLeadingSpace = true
 	cr.FieldsPerRecord = -1
 
-	rows, err := cr.ReadAll()
-	if err != nil {
-		return nil, err
+	var rows [][]string
+	for {
+		if len(rows)%checkEvery == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
 	}
 	if len(rows) == 0 {
 		return nil, fmt.Errorf("csv is empty")
@@ -54,7 +70,7 @@ func ParseTransactionsCSV(r io.Reader, userEmail string, now time.Time) ([]domai
 
 		out = append(out, domain.Transaction{
 			ID:         uint(idU64),
-			UserEmail:  userEmail,
+			AccountID:  accountID,
 			OccurredAt: t,
 			Amount:     amt,
 			RawDate:    rawDate,
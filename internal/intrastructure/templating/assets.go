@@ -0,0 +1,84 @@
+package templating
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"mime"
+	"path"
+	"strings"
+
+	"github.com/Vasenti/stori_challenge/internal/domain"
+)
+
+// LayerAssets looks files up in each fs.FS in order, so files next to a
+// custom template can override or extend the embedded ones.
+func LayerAssets(layers ...fs.FS) fs.FS {
+	return layeredFS(layers)
+}
+
+type layeredFS []fs.FS
+
+func (l layeredFS) Open(name string) (fs.File, error) {
+	for _, fsys := range l {
+		if fsys == nil {
+			continue
+		}
+		f, err := fsys.Open(name)
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// assetCollector backs the "asset" template function: it loads each
+// referenced file once and hands out its cid: URL.
+type assetCollector struct {
+	fsys   fs.FS
+	byName map[string]domain.EmailAsset
+	order  []string
+}
+
+func newAssetCollector(fsys fs.FS) *assetCollector {
+	if fsys == nil {
+		fsys = Embedded
+	}
+	return &assetCollector{fsys: fsys, byName: map[string]domain.EmailAsset{}}
+}
+
+func (c *assetCollector) url(name string) (template.URL, error) {
+	name = strings.TrimPrefix(path.Clean(name), "/")
+	if a, ok := c.byName[name]; ok {
+		return template.URL("cid:" + a.ContentID), nil
+	}
+	data, err := fs.ReadFile(c.fsys, name)
+	if err != nil {
+		return "", fmt.Errorf("asset %q: %w", name, err)
+	}
+	ctype := mime.TypeByExtension(path.Ext(name))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+	a := domain.EmailAsset{
+		ContentID:   strings.NewReplacer("/", ".", " ", "-").Replace(name) + "@report",
+		ContentType: ctype,
+		Filename:    path.Base(name),
+		Data:        data,
+	}
+	c.byName[name] = a
+	c.order = append(c.order, name)
+	return template.URL("cid:" + a.ContentID), nil
+}
+
+func (c *assetCollector) assets() []domain.EmailAsset {
+	out := make([]domain.EmailAsset, 0, len(c.order))
+	for _, name := range c.order {
+		out = append(out, c.byName[name])
+	}
+	return out
+}
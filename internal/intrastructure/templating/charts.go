@@ -0,0 +1,166 @@
+package templating
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/Vasenti/stori_challenge/internal/domain"
+	"github.com/Vasenti/stori_challenge/internal/i18n"
+)
+
+const (
+	chartWidth  = 552
+	chartHeight = 200
+	chartPad    = 32
+
+	colorCredit  = "#065f46"
+	colorDebit   = "#b91c1c"
+	colorBalance = "#4338ca"
+	colorGrid    = "#e5e7eb"
+	colorLabel   = "#6b7280"
+)
+
+// MonthFlow is one month of money in/out. Balance is the running balance at
+// the end of the month, opening balance included. CreditPct and DebitPct are
+// relative to the busiest month (0-100) so templates can draw HTML bar
+// fallbacks.
+type MonthFlow struct {
+	Year      int        `json:"year"`
+	Month     time.Month `json:"month"`
+	MonthName string     `json:"month_name"`
+	Credits   float64    `json:"credits"`
+	Debits    float64    `json:"debits"`
+	Balance   float64    `json:"balance"`
+	CreditPct int        `json:"-"`
+	DebitPct  int        `json:"-"`
+}
+
+// Charts holds inline SVG renderings of the summary. Clients that block SVG
+// (Gmail, Outlook desktop) should get the HTML fallback drawn from Model.Flow.
+type Charts struct {
+	MonthlyFlow    template.HTML
+	RunningBalance template.HTML
+}
+
+// buildFlow lays out months (oldest first) with the running balance seeded
+// by the summary's opening balance.
+func buildFlow(summary domain.MonthlySummary, months []domain.YearMonth, locale domain.Locale) []MonthFlow {
+	var flow []MonthFlow
+	var peak float64
+	balance := summary.OpeningBalance
+	for _, m := range months {
+		c, d := summary.CreditsByMonth[m], summary.DebitsByMonth[m]
+		balance += c - d
+		peak = max(peak, c, d)
+		flow = append(flow, MonthFlow{Year: m.Year, Month: m.Month, MonthName: monthLabel(locale, m, months), Credits: c, Debits: d, Balance: balance})
+	}
+	if peak > 0 {
+		for i := range flow {
+			flow[i].CreditPct = int(math.Round(flow[i].Credits / peak * 100))
+			flow[i].DebitPct = int(math.Round(flow[i].Debits / peak * 100))
+		}
+	}
+	return flow
+}
+
+func buildCharts(flow []MonthFlow, locale domain.Locale, currency string) Charts {
+	if len(flow) == 0 {
+		return Charts{}
+	}
+	return Charts{
+		MonthlyFlow:    flowSVG(flow, locale, currency),
+		RunningBalance: balanceSVG(flow, locale, currency),
+	}
+}
+
+func svgOpen(b *strings.Builder, w, h int, title string) {
+	fmt.Fprintf(b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" role="img" aria-label="%s" style="display:block;max-width:100%%;height:auto;font-family:Arial,Helvetica,sans-serif;">`,
+		w, h, w, h, html.EscapeString(title))
+	fmt.Fprintf(b, `<title>%s</title>`, html.EscapeString(title))
+}
+
+func svgText(b *strings.Builder, x, y float64, anchor, color, text string) {
+	fmt.Fprintf(b, `<text x="%.1f" y="%.1f" text-anchor="%s" font-size="11" fill="%s">%s</text>`, x, y, anchor, color, html.EscapeString(text))
+}
+
+func shortMonth(name string) string {
+	r := []rune(name)
+	return strings.ToUpper(string(r[:min(3, len(r))]))
+}
+
+// flowSVG draws grouped credit/debit bars per month.
+func flowSVG(flow []MonthFlow, locale domain.Locale, currency string) template.HTML {
+	var peak float64
+	for _, f := range flow {
+		peak = max(peak, f.Credits, f.Debits)
+	}
+	if peak == 0 {
+		peak = 1
+	}
+
+	var b strings.Builder
+	svgOpen(&b, chartWidth, chartHeight, i18n.T(locale, "chart_flow"))
+	plotH := float64(chartHeight - 2*chartPad)
+	plotW := float64(chartWidth - 2*chartPad)
+	base := float64(chartHeight - chartPad)
+
+	fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="%s"/>`, chartPad, base, chartWidth-chartPad, base, colorGrid)
+	fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="%s" stroke-dasharray="3 3"/>`, chartPad, chartPad, chartWidth-chartPad, chartPad, colorGrid)
+	svgText(&b, chartPad, chartPad-6, "start", colorLabel, i18n.Money(locale, currency, peak))
+
+	group := plotW / float64(len(flow))
+	bar := math.Min(group*0.35, 28)
+	for i, f := range flow {
+		cx := float64(chartPad) + group*(float64(i)+0.5)
+		ch := f.Credits / peak * plotH
+		dh := f.Debits / peak * plotH
+		fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%s</title></rect>`,
+			cx-bar, base-ch, bar, ch, colorCredit, html.EscapeString(i18n.Money(locale, currency, f.Credits)))
+		fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%s</title></rect>`,
+			cx, base-dh, bar, dh, colorDebit, html.EscapeString(i18n.Money(locale, currency, -f.Debits)))
+		svgText(&b, cx, base+16, "middle", colorLabel, shortMonth(f.MonthName))
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// balanceSVG draws the running balance at the end of each month.
+func balanceSVG(flow []MonthFlow, locale domain.Locale, currency string) template.HTML {
+	lo, hi := 0.0, 0.0
+	for _, f := range flow {
+		lo, hi = math.Min(lo, f.Balance), math.Max(hi, f.Balance)
+	}
+	if hi == lo {
+		hi = lo + 1
+	}
+
+	var b strings.Builder
+	svgOpen(&b, chartWidth, chartHeight, i18n.T(locale, "chart_balance"))
+	plotH := float64(chartHeight - 2*chartPad)
+	plotW := float64(chartWidth - 2*chartPad)
+	y := func(v float64) float64 { return float64(chartPad) + (hi-v)/(hi-lo)*plotH }
+	x := func(i int) float64 {
+		if len(flow) == 1 {
+			return float64(chartPad) + plotW/2
+		}
+		return float64(chartPad) + plotW*float64(i)/float64(len(flow)-1)
+	}
+
+	fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="%s" stroke-dasharray="3 3"/>`, chartPad, y(0), chartWidth-chartPad, y(0), colorGrid)
+	points := make([]string, len(flow))
+	for i, f := range flow {
+		points[i] = fmt.Sprintf("%.1f,%.1f", x(i), y(f.Balance))
+	}
+	fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="2.5" stroke-linejoin="round"/>`, strings.Join(points, " "), colorBalance)
+	for i, f := range flow {
+		fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="3.5" fill="%s"><title>%s</title></circle>`, x(i), y(f.Balance), colorBalance, html.EscapeString(i18n.Money(locale, currency, f.Balance)))
+		svgText(&b, x(i), float64(chartHeight-chartPad)+16, "middle", colorLabel, shortMonth(f.MonthName))
+	}
+	svgText(&b, chartWidth-chartPad, chartPad-12, "end", colorLabel, i18n.Money(locale, currency, hi))
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
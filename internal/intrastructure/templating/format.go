@@ -0,0 +1,83 @@
+package templating
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	texttemplate "text/template"
+
+	"github.com/Vasenti/stori_challenge/internal/domain"
+)
+
+// Format selects how a report is rendered. HTML goes through a Template and
+// the email pipeline; the other formats are rendered by RenderText.
+type Format string
+
+const (
+	FormatHTML     Format = "html"
+	FormatText     Format = "text"
+	FormatMarkdown Format = "md"
+	FormatJSON     Format = "json"
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(strings.TrimSpace(s))); f {
+	case "":
+		return FormatHTML, nil
+	case FormatHTML, FormatText, FormatMarkdown, FormatJSON:
+		return f, nil
+	case "markdown":
+		return FormatMarkdown, nil
+	case "txt":
+		return FormatText, nil
+	}
+	return "", domain.Errorf(domain.ErrValidation, "unknown output format %q (html, text, md, json)", s)
+}
+
+// RenderText renders model as plain text (aligned for a terminal), Markdown
+// or JSON. The text and Markdown layouts are the embedded monthly.txt.tmpl
+// and monthly.md.tmpl, which get the same function library as HTML templates.
+func RenderText(model Model, format Format) (string, error) {
+	switch format {
+	case FormatJSON:
+		b, err := json.MarshalIndent(model, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		return string(b) + "\n", nil
+	case FormatText:
+		out, err := execText(DefaultTemplate+".txt.tmpl", model)
+		if err != nil {
+			return "", err
+		}
+		// Columns are tab separated in the template and aligned here.
+		var buf bytes.Buffer
+		w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+		if _, err := w.Write([]byte(out)); err != nil {
+			return "", err
+		}
+		if err := w.Flush(); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	case FormatMarkdown:
+		return execText(DefaultTemplate+".md.tmpl", model)
+	}
+	return "", fmt.Errorf("format %q is not a text format", format)
+}
+
+func execText(name string, model Model) (string, error) {
+	tpl, err := texttemplate.New(name).
+		Funcs(texttemplate.FuncMap(Funcs(model.Locale, model.Currency))).
+		ParseFS(Embedded, name)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, model); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
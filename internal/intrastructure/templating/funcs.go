@@ -0,0 +1,145 @@
+package templating
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"reflect"
+	"time"
+
+	"github.com/Vasenti/stori_challenge/internal/domain"
+	"github.com/Vasenti/stori_challenge/internal/i18n"
+)
+
+// Funcs returns the template function library bound to locale. Numeric
+// arguments accept any int or float value (e.g. .Count or .AvgDebit).
+//
+// Localization
+//
+//	t "key" args...        catalog message (see i18n.T)
+//	money 12.5             "$12.50", "-$10.30"; "MXN 12.50" when the
+//	                       report's currency is not the locale's
+//	number 1234.5          "1,234.50"
+//	month .Month           localized month name; also accepts 1..12
+//	monthYear .Now         "September 2025" / "septiembre 2025"
+//	date .Now              long date
+//	dateTime .Now          short date and time
+//
+// Math
+//
+//	abs -3.5               3.5
+//	div 10 4               2.5; 0 when dividing by zero
+//	percent 1 4            "25.00%"; "0.00%" when the total is zero
+//
+// Dates
+//
+//	addDays .Now -7        time shifted by n days
+//	addMonths .Now -1      time shifted by n months
+//	startOfMonth .Now      first instant of the month
+//	endOfMonth .Now        last instant of the month
+//
+// Conditional classes
+//
+//	classIf cond "a" "b"   "a" when cond is true, else "b" (or "")
+//	signClass .BalanceTotal "positive", "negative" or "zero"
+//
+// Assets
+//
+//	asset "logo.svg"       cid: URL of an inline image; the file is read from
+//	                       the template's asset directory or the embedded
+//	                       defaults and attached to the email
+//
+// Outlook conditional comments (html/template drops literal comments)
+//
+//	{{ ifMSO }}...{{ endMSO }}          only rendered by Outlook desktop
+//	{{ ifNotMSO }}...{{ endNotMSO }}    hidden from Outlook desktop
+func Funcs(locale domain.Locale, currency string) template.FuncMap {
+	return template.FuncMap{
+		"t":         func(key string, args ...any) string { return i18n.T(locale, key, args...) },
+		"money":     func(v any) (string, error) { f, err := toFloat(v); return i18n.Money(locale, currency, f), err },
+		"number":    func(v any) (string, error) { f, err := toFloat(v); return i18n.Number(locale, f), err },
+		"month":     func(m any) (string, error) { mm, err := toMonth(m); return i18n.MonthName(locale, mm), err },
+		"monthYear": func(t time.Time) string { return i18n.MonthYear(locale, t) },
+		"date":      func(t time.Time) string { return i18n.Date(locale, t) },
+		"dateTime":  func(t time.Time) string { return i18n.DateTime(locale, t) },
+
+		"abs": func(v any) (float64, error) { f, err := toFloat(v); return math.Abs(f), err },
+		"div": safeDiv,
+		"percent": func(part, total any) (string, error) {
+			ratio, err := safeDiv(part, total)
+			return i18n.Number(locale, ratio*100) + "%", err
+		},
+
+		"addDays":      func(t time.Time, n int) time.Time { return t.AddDate(0, 0, n) },
+		"addMonths":    func(t time.Time, n int) time.Time { return t.AddDate(0, n, 0) },
+		"startOfMonth": startOfMonth,
+		"endOfMonth":   func(t time.Time) time.Time { return startOfMonth(t).AddDate(0, 1, 0).Add(-time.Nanosecond) },
+
+		"classIf": func(cond bool, class string, otherwise ...string) string {
+			if cond {
+				return class
+			}
+			if len(otherwise) > 0 {
+				return otherwise[0]
+			}
+			return ""
+		},
+		"ifMSO":     func() template.HTML { return `<!--[if mso]>` },
+		"endMSO":    func() template.HTML { return `<![endif]-->` },
+		"ifNotMSO":  func() template.HTML { return `<!--[if !mso]><!-->` },
+		"endNotMSO": func() template.HTML { return `<!--<![endif]-->` },
+		"signClass": func(v any) (string, error) {
+			f, err := toFloat(v)
+			switch {
+			case f > 0:
+				return "positive", err
+			case f < 0:
+				return "negative", err
+			}
+			return "zero", err
+		},
+	}
+}
+
+func safeDiv(a, b any) (float64, error) {
+	fa, err := toFloat(a)
+	if err != nil {
+		return 0, err
+	}
+	fb, err := toFloat(b)
+	if err != nil || fb == 0 {
+		return 0, err
+	}
+	return fa / fb, nil
+}
+
+func startOfMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
+
+func toFloat(v any) (float64, error) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), nil
+	}
+	return 0, fmt.Errorf("expected a number, got %T", v)
+}
+
+func toMonth(v any) (time.Month, error) {
+	if m, ok := v.(time.Month); ok {
+		return m, nil
+	}
+	f, err := toFloat(v)
+	if err != nil {
+		return 0, err
+	}
+	if f < 1 || f > 12 {
+		return 0, fmt.Errorf("month out of range: %v", v)
+	}
+	return time.Month(f), nil
+}
@@ -0,0 +1,178 @@
+package templating
+
+import (
+	"sort"
+	"time"
+
+	"github.com/Vasenti/stori_challenge/internal/domain"
+	"github.com/Vasenti/stori_challenge/internal/i18n"
+)
+
+type MonthCount struct {
+	Year      int        `json:"year"`
+	Month     time.Month `json:"month"`
+	MonthName string     `json:"month_name"`
+	Count     int        `json:"count"`
+}
+
+type TransactionRow struct {
+	ID         uint      `json:"id"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Amount     float64   `json:"amount"`
+	IsCredit   bool      `json:"is_credit"`
+}
+
+// AccountRow is one account's totals; reports list them when the user has
+// more than one account.
+type AccountRow struct {
+	Name         string  `json:"name"`
+	Type         string  `json:"type"`
+	Currency     string  `json:"currency,omitempty"`
+	BalanceTotal float64 `json:"balance_total"`
+	AvgDebit     float64 `json:"avg_debit"`
+	AvgCredit    float64 `json:"avg_credit"`
+	Count        int     `json:"transactions_count"`
+}
+
+// Model is what every report format is rendered from. The json tags define
+// the JSON output; Charts is HTML only.
+type Model struct {
+	UserEmail string    `json:"user_email"`
+	Now       time.Time `json:"generated_at"`
+	// Month is the month the report covers: its period's first day, or Now
+	// for all-time reports.
+	Month        time.Time     `json:"month"`
+	Locale       domain.Locale `json:"locale"`
+	Lang         string        `json:"-"`
+	Currency     string        `json:"currency,omitempty"`
+	BalanceTotal float64       `json:"balance_total"`
+	AvgDebit     float64       `json:"avg_debit"`
+	AvgCredit    float64       `json:"avg_credit"`
+	ByMonth      []MonthCount  `json:"by_month"`
+	// Accounts breaks the consolidated totals above down per account.
+	Accounts []AccountRow `json:"accounts,omitempty"`
+	// Flow is the per-month credits/debits/running balance behind Charts.
+	Flow   []MonthFlow `json:"flow,omitempty"`
+	Charts Charts      `json:"-"`
+
+	// Transaction detail, filled by AddTransactions. Transactions is sorted
+	// newest first and capped; MoreTransactions counts the rows left out.
+	HasDetails       bool             `json:"-"`
+	Transactions     []TransactionRow `json:"transactions,omitempty"`
+	MoreTransactions int              `json:"more_transactions,omitempty"`
+	TopDebits        []TransactionRow `json:"top_debits,omitempty"`
+	TopCredits       []TransactionRow `json:"top_credits,omitempty"`
+}
+
+type DetailOptions struct {
+	// MaxRows caps Transactions; 0 leaves the list empty (top-N still filled).
+	MaxRows int
+	// TopN is the size of TopDebits and TopCredits.
+	TopN int
+}
+
+func BuildModel(summary domain.MonthlySummary, userEmail string, now time.Time, locale domain.Locale) Model {
+	months := sortedMonths(summary)
+	byMonth := make([]MonthCount, 0, len(months))
+	for _, m := range months {
+		byMonth = append(byMonth, MonthCount{Year: m.Year, Month: m.Month, MonthName: monthLabel(locale, m, months), Count: summary.TransactionsByMonth[m]})
+	}
+	flow := buildFlow(summary, months, locale)
+
+	var accounts []AccountRow
+	for _, a := range summary.Accounts {
+		accounts = append(accounts, AccountRow{
+			Name:         a.Account.Name,
+			Type:         string(a.Account.Type),
+			Currency:     a.Account.Currency,
+			BalanceTotal: a.BalanceTotal,
+			AvgDebit:     a.AvgDebit,
+			AvgCredit:    a.AvgCredit,
+			Count:        a.Transactions,
+		})
+	}
+
+	month := now
+	if !summary.Period.IsZero() {
+		month = summary.Period.From
+	}
+
+	return Model{
+		UserEmail:    userEmail,
+		Now:          now,
+		Month:        month,
+		Locale:       locale,
+		Lang:         locale.Lang(),
+		Currency:     summary.Currency,
+		BalanceTotal: summary.BalanceTotal,
+		AvgDebit:     summary.AvgDebit,
+		AvgCredit:    summary.AvgCredit,
+		ByMonth:      byMonth,
+		Accounts:     accounts,
+		Flow:         flow,
+		Charts:       buildCharts(flow, locale, summary.Currency),
+	}
+}
+
+// sortedMonths returns the months summary has transactions in, oldest first.
+func sortedMonths(summary domain.MonthlySummary) []domain.YearMonth {
+	months := make([]domain.YearMonth, 0, len(summary.TransactionsByMonth))
+	for m := range summary.TransactionsByMonth {
+		months = append(months, m)
+	}
+	sort.Slice(months, func(i, j int) bool { return months[i].Before(months[j]) })
+	return months
+}
+
+// monthLabel names m, adding the year when months span more than one.
+func monthLabel(locale domain.Locale, m domain.YearMonth, months []domain.YearMonth) string {
+	if len(months) > 0 && months[0].Year != months[len(months)-1].Year {
+		return i18n.MonthYear(locale, time.Date(m.Year, m.Month, 1, 0, 0, 0, 0, time.UTC))
+	}
+	return i18n.MonthName(locale, m.Month)
+}
+
+// SetChartMode picks how charts reach the template: "svg" (default) keeps the
+// inline SVG, "html" drops it so templates use the HTML bars built from Flow,
+// and "off" drops both.
+func (m *Model) SetChartMode(mode string) {
+	switch mode {
+	case "html":
+		m.Charts = Charts{}
+	case "off":
+		m.Charts = Charts{}
+		m.Flow = nil
+	}
+}
+
+// AddTransactions fills the transaction detail section of the model.
+func (m *Model) AddTransactions(txs []domain.Transaction, opts DetailOptions) {
+	rows := make([]TransactionRow, 0, len(txs))
+	for _, t := range txs {
+		rows = append(rows, TransactionRow{ID: t.ID, OccurredAt: t.OccurredAt, Amount: t.Amount, IsCredit: t.Amount > 0})
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if !rows[i].OccurredAt.Equal(rows[j].OccurredAt) {
+			return rows[i].OccurredAt.After(rows[j].OccurredAt)
+		}
+		return rows[i].ID > rows[j].ID
+	})
+
+	m.HasDetails = len(rows) > 0
+	shown := min(max(opts.MaxRows, 0), len(rows))
+	m.Transactions = rows[:shown]
+	m.MoreTransactions = len(rows) - shown
+
+	var debits, credits []TransactionRow
+	for _, r := range rows {
+		if r.Amount < 0 {
+			debits = append(debits, r)
+		} else if r.Amount > 0 {
+			credits = append(credits, r)
+		}
+	}
+	sort.SliceStable(debits, func(i, j int) bool { return debits[i].Amount < debits[j].Amount })
+	sort.SliceStable(credits, func(i, j int) bool { return credits[i].Amount > credits[j].Amount })
+	m.TopDebits = debits[:min(max(opts.TopN, 0), len(debits))]
+	m.TopCredits = credits[:min(max(opts.TopN, 0), len(credits))]
+}
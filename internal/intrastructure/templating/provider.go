@@ -0,0 +1,206 @@
+package templating
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Vasenti/stori_challenge/internal/application/ports"
+	"github.com/Vasenti/stori_challenge/internal/domain"
+)
+
+const (
+	templateExt = ".html.tmpl"
+
+	DefaultTemplate = "monthly"
+)
+
+//go:embed templates
+var embedded embed.FS
+
+// Embedded holds the built-in templates, partials and images.
+var Embedded fs.FS = mustSub(embedded, "templates")
+
+func mustSub(fsys fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+type ProviderOptions struct {
+	// Dir holds named templates ("<name>.html.tmpl"), partials and assets; a
+	// local directory or an s3://bucket/prefix. Names missing there fall back
+	// to the embedded templates.
+	Dir string
+	// Default is the reference used when Resolve gets "" (DefaultTemplate if empty).
+	Default string
+	// CacheTTL is how long resolved templates are reused; 0 caches forever
+	// and a negative value disables caching.
+	CacheTTL time.Duration
+	// S3 builds the reader used for s3:// references, on first use.
+	S3 func() (ports.Reader, error)
+}
+
+// Provider resolves template references to parsed templates. A reference is
+// either a name ("monthly"), a local file ("./templates/report.html.tmpl")
+// or an S3 object ("s3://bucket/templates/report.html.tmpl"). Partials and
+// assets are looked up next to the referenced file, then in Embedded.
+type Provider struct {
+	opts ProviderOptions
+
+	mu    sync.Mutex
+	cache map[string]cachedTemplate
+	s3    ports.Reader
+}
+
+type cachedTemplate struct {
+	tpl      *Template
+	loadedAt time.Time
+}
+
+func NewProvider(opts ProviderOptions) *Provider {
+	if opts.Default == "" {
+		opts.Default = DefaultTemplate
+	}
+	return &Provider{opts: opts, cache: map[string]cachedTemplate{}}
+}
+
+// Resolve returns the parsed template for ref. ctx bounds the reads of the
+// page and its partials.
+func (p *Provider) Resolve(ctx context.Context, ref string) (*Template, error) {
+	if ref == "" {
+		ref = p.opts.Default
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if c, ok := p.cache[ref]; ok && (p.opts.CacheTTL == 0 || time.Since(c.loadedAt) < p.opts.CacheTTL) {
+		return c.tpl, nil
+	}
+
+	fsys, file, err := p.locate(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	// Assets are read at render time, possibly by later requests sharing the
+	// cached template, so they must not inherit this request's deadline.
+	assets, _, err := p.locate(context.WithoutCancel(ctx), ref)
+	if err != nil {
+		return nil, err
+	}
+	src, err := fs.ReadFile(fsys, file)
+	if err != nil {
+		return nil, invalid(ctx, fmt.Errorf("template %q: %w", ref, err))
+	}
+	tpl, err := loadSource(strings.TrimSuffix(file, templateExt), string(src), fsys, assets)
+	if err != nil {
+		return nil, invalid(ctx, err)
+	}
+
+	if p.opts.CacheTTL >= 0 {
+		p.cache[ref] = cachedTemplate{tpl: tpl, loadedAt: time.Now()}
+	}
+	return tpl, nil
+}
+
+// invalid tags a load failure as an invalid template, unless it happened
+// because ctx was canceled or timed out.
+func invalid(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return err
+	}
+	return domain.Wrap("", domain.ErrTemplateInvalid, err)
+}
+
+// locate maps ref to the file system its template set lives in and the
+// file name of the page inside it.
+func (p *Provider) locate(ctx context.Context, ref string) (fs.FS, string, error) {
+	switch {
+	case strings.HasPrefix(ref, "s3://"):
+		dir, file := path.Split(ref)
+		fsys, err := p.s3FS(ctx, strings.TrimSuffix(dir, "/"))
+		if err != nil {
+			return nil, "", err
+		}
+		return LayerAssets(fsys, Embedded), file, nil
+	case strings.ContainsAny(ref, `/\`) || path.Ext(ref) != "":
+		return LayerAssets(os.DirFS(filepath.Dir(ref)), Embedded), filepath.Base(ref), nil
+	}
+
+	if p.opts.Dir == "" {
+		return Embedded, ref + templateExt, nil
+	}
+	dir := os.DirFS(p.opts.Dir)
+	if strings.HasPrefix(p.opts.Dir, "s3://") {
+		var err error
+		if dir, err = p.s3FS(ctx, strings.TrimSuffix(p.opts.Dir, "/")); err != nil {
+			return nil, "", err
+		}
+	}
+	return LayerAssets(dir, Embedded), ref + templateExt, nil
+}
+
+func (p *Provider) s3FS(ctx context.Context, prefix string) (fs.FS, error) {
+	if p.s3 == nil {
+		if p.opts.S3 == nil {
+			return nil, fmt.Errorf("template %s: s3 is not configured", prefix)
+		}
+		r, err := p.opts.S3()
+		if err != nil {
+			return nil, err
+		}
+		p.s3 = r
+	}
+	return readerFS{ctx: ctx, r: p.s3, prefix: prefix}, nil
+}
+
+// readerFS exposes the objects under prefix as an fs.FS through a ports.Reader;
+// fs.FS has no context, so reads are bound to ctx.
+type readerFS struct {
+	ctx    context.Context
+	r      ports.Reader
+	prefix string
+}
+
+func (f readerFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	rc, err := f.r.Open(f.ctx, f.prefix+"/"+name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	return &memFile{Reader: bytes.NewReader(data), name: path.Base(name), size: int64(len(data))}, nil
+}
+
+type memFile struct {
+	*bytes.Reader
+	name string
+	size int64
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f, nil }
+func (f *memFile) Close() error               { return nil }
+func (f *memFile) Name() string               { return f.name }
+func (f *memFile) Size() int64                { return f.size }
+func (f *memFile) Mode() fs.FileMode          { return 0o444 }
+func (f *memFile) ModTime() time.Time         { return time.Time{} }
+func (f *memFile) IsDir() bool                { return false }
+func (f *memFile) Sys() any                   { return nil }
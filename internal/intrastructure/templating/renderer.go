@@ -2,55 +2,159 @@ package templating
 
 import (
 	"bytes"
+	"fmt"
 	"html/template"
-	"sort"
-	"time"
-	_ "embed"
+	"io/fs"
+	"regexp"
+	"text/template/parse"
+
 	"github.com/Vasenti/stori_challenge/internal/domain"
 )
 
-//go:embed default_report.html.tmpl
-var defaultHTML []byte
+type Rendered struct {
+	HTML string
+	// Inline holds the assets referenced with {{ asset "..." }}.
+	Inline []domain.EmailAsset
+}
 
-type MonthCount struct {
-	MonthName string
-	Count     int
+// Template is a parsed template set: the page, its layout and every partial
+// it references. It is safe for concurrent use; each Render works on a clone.
+type Template struct {
+	Name   string
+	entry  string
+	set    *template.Template
+	assets fs.FS
 }
-type Model struct {
-	UserEmail    string
-	Now          time.Time
-	BalanceTotal float64
-	AvgDebit     float64
-	AvgCredit    float64
-	ByMonth      []MonthCount
+
+func (t *Template) Render(model Model) (Rendered, error) {
+	collector := newAssetCollector(t.assets)
+	set, err := t.bind(model.Locale, model.Currency, collector)
+	if err != nil {
+		return Rendered{}, err
+	}
+	var buf bytes.Buffer
+	if err := set.ExecuteTemplate(&buf, t.entry, model); err != nil {
+		return Rendered{}, domain.Wrap("", domain.ErrTemplateInvalid, err)
+	}
+	return Rendered{HTML: buf.String(), Inline: collector.assets()}, nil
 }
 
-func Render(summary domain.MonthlySummary, userEmail, tpl string, now time.Time) (string, error) {
-	src := defaultHTML
-	if tpl != "" {
-		src = []byte(tpl)
+// bind returns a clone of the set with the functions bound to locale and
+// currency.
+func (t *Template) bind(locale domain.Locale, currency string, assets *assetCollector) (*template.Template, error) {
+	set, err := t.set.Clone()
+	if err != nil {
+		return nil, err
 	}
-	byMonth := make([]MonthCount, 0, 12)
-	for m, c := range summary.TransactionsByMonth {
-		byMonth = append(byMonth, MonthCount{MonthName: m.String(), Count: c})
+	return set.Funcs(funcsWithAssets(locale, currency, assets)), nil
+}
+
+func funcsWithAssets(locale domain.Locale, currency string, assets *assetCollector) template.FuncMap {
+	funcs := Funcs(locale, currency)
+	funcs["asset"] = assets.url
+	return funcs
+}
+
+// Inline parses template source that does not come from a file. Partials and
+// assets resolve against the embedded defaults.
+func Inline(src string) (*Template, error) {
+	tpl, err := loadSource("inline", src, Embedded, Embedded)
+	return tpl, domain.Wrap("", domain.ErrTemplateInvalid, err)
+}
+
+// loadSource parses src as the page name, plus the layout it declares and
+// every partial referenced through {{ template "name" }}, read from fsys as
+// "<name>.html.tmpl".
+func loadSource(name, src string, fsys, assets fs.FS) (*Template, error) {
+	set := template.New(name).Funcs(funcsWithAssets(domain.DefaultLocale, "", newAssetCollector(assets)))
+	if _, err := set.Parse(src); err != nil {
+		return nil, err
 	}
-	sort.Slice(byMonth, func(i, j int) bool { return byMonth[i].MonthName < byMonth[j].MonthName })
 
-	model := Model{
-		UserEmail:    userEmail,
-		Now:          now,
-		BalanceTotal: summary.BalanceTotal,
-		AvgDebit:     summary.AvgDebit,
-		AvgCredit:    summary.AvgCredit,
-		ByMonth:      byMonth,
+	entry := name
+	if layout := layoutOf(src); layout != "" {
+		entry = layout
 	}
-	t, err := template.New("report").Parse(string(src))
-	if err != nil {
-		return "", err
+
+	tried := map[string]bool{}
+	for {
+		missing := undefinedTemplates(set, entry)
+		if len(missing) == 0 {
+			break
+		}
+		for _, ref := range missing {
+			if tried[ref] {
+				return nil, fmt.Errorf("template %q: %q is not defined", name, ref)
+			}
+			tried[ref] = true
+			b, err := fs.ReadFile(fsys, ref+templateExt)
+			if err != nil {
+				return nil, fmt.Errorf("template %q: load %q: %w", name, ref, err)
+			}
+			if _, err := set.New(ref).Parse(string(b)); err != nil {
+				return nil, err
+			}
+		}
 	}
-	var buf bytes.Buffer
-	if err := t.Execute(&buf, model); err != nil {
-		return "", err
+	return &Template{Name: name, entry: entry, set: set, assets: assets}, nil
+}
+
+// layoutOf reads the optional layout directive on the first line of a page:
+//
+//	{{/* layout: layouts/base */}}
+func layoutOf(src string) string {
+	m := layoutDirective.FindStringSubmatch(src)
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+var layoutDirective = regexp.MustCompile(`^\s*\{\{-?\s*/\*\s*layout:\s*([\w./-]+)\s*\*/\s*-?\}\}`)
+
+// undefinedTemplates lists the names used by {{ template }} calls (and the
+// entry itself) that have no definition in set yet.
+func undefinedTemplates(set *template.Template, entry string) []string {
+	defined := func(name string) bool {
+		t := set.Lookup(name)
+		return t != nil && t.Tree != nil
+	}
+	seen := map[string]bool{}
+	var missing []string
+	add := func(name string) {
+		if !seen[name] && !defined(name) {
+			seen[name] = true
+			missing = append(missing, name)
+		}
 	}
-	return buf.String(), nil
-}
\ No newline at end of file
+	add(entry)
+	for _, t := range set.Templates() {
+		if t.Tree != nil {
+			templateRefs(t.Tree.Root, add)
+		}
+	}
+	return missing
+}
+
+func templateRefs(n parse.Node, visit func(string)) {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			templateRefs(c, visit)
+		}
+	case *parse.IfNode:
+		templateRefs(n.List, visit)
+		templateRefs(n.ElseList, visit)
+	case *parse.RangeNode:
+		templateRefs(n.List, visit)
+		templateRefs(n.ElseList, visit)
+	case *parse.WithNode:
+		templateRefs(n.List, visit)
+		templateRefs(n.ElseList, visit)
+	case *parse.TemplateNode:
+		visit(n.Name)
+	}
+}
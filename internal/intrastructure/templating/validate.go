@@ -0,0 +1,218 @@
+package templating
+
+import (
+	"fmt"
+	"reflect"
+	"text/template/parse"
+	"time"
+
+	"github.com/Vasenti/stori_challenge/internal/domain"
+)
+
+// SampleModel returns a representative Model used to dry-run templates.
+func SampleModel(locale domain.Locale) Model {
+	now := time.Date(2025, time.October, 5, 14, 30, 0, 0, time.UTC)
+	m := BuildModel(domain.MonthlySummary{
+		Currency:            "USD",
+		BalanceTotal:        39.74,
+		TransactionsByMonth: map[domain.YearMonth]int{{Year: 2025, Month: time.July}: 2, {Year: 2025, Month: time.August}: 2},
+		AvgDebit:            15.38,
+		AvgCredit:           35.25,
+		CreditsByMonth:      map[domain.YearMonth]float64{{Year: 2025, Month: time.July}: 60.5, {Year: 2025, Month: time.August}: 10},
+		DebitsByMonth:       map[domain.YearMonth]float64{{Year: 2025, Month: time.July}: 10.3, {Year: 2025, Month: time.August}: 20.46},
+		Accounts: []domain.AccountSummary{
+			{Account: domain.Account{Name: "card", Type: domain.AccountCredit, Currency: "USD"}, BalanceTotal: -10.46, AvgDebit: 20.46, AvgCredit: 10, Transactions: 2},
+			{Account: domain.Account{Name: domain.DefaultAccountName, Type: domain.AccountDebit, Currency: "USD"}, BalanceTotal: 50.2, AvgDebit: 10.3, AvgCredit: 60.5, Transactions: 2},
+		},
+	}, "sample@example.com", now, locale)
+	m.AddTransactions([]domain.Transaction{
+		{ID: 0, OccurredAt: time.Date(2025, time.July, 15, 0, 0, 0, 0, time.UTC), Amount: 60.5},
+		{ID: 1, OccurredAt: time.Date(2025, time.July, 28, 0, 0, 0, 0, time.UTC), Amount: -10.3},
+		{ID: 2, OccurredAt: time.Date(2025, time.August, 2, 0, 0, 0, 0, time.UTC), Amount: -20.46},
+		{ID: 3, OccurredAt: time.Date(2025, time.August, 13, 0, 0, 0, 0, time.UTC), Amount: 10},
+	}, DetailOptions{MaxRows: 3, TopN: 2})
+	return m
+}
+
+// Validate statically checks every field reference of the template set
+// against Model and renders it with SampleModel. It returns one message per
+// problem found; nil means the template is usable. Parse errors and missing
+// partials are already reported by Provider.Resolve.
+func (t *Template) Validate(locale domain.Locale) []string {
+	v := &validator{root: reflect.TypeOf(Model{}), seen: map[string]bool{}}
+	for _, tt := range t.set.Templates() {
+		if tt.Tree == nil {
+			continue
+		}
+		v.trees = append(v.trees, tt.Tree)
+	}
+	if entry := t.set.Lookup(t.entry); entry != nil && entry.Tree != nil {
+		v.walkTree(entry.Tree, v.root)
+	}
+
+	if _, err := t.Render(SampleModel(locale)); err != nil {
+		v.problems = append(v.problems, "render: "+err.Error())
+	}
+	return v.problems
+}
+
+type validator struct {
+	root     reflect.Type
+	trees    []*parse.Tree
+	seen     map[string]bool
+	problems []string
+	tree     *parse.Tree
+}
+
+func (v *validator) walkTree(tree *parse.Tree, dot reflect.Type) {
+	key := tree.Name + "|" + fmt.Sprint(dot)
+	if tree.Root == nil || v.seen[key] {
+		return
+	}
+	v.seen[key] = true
+	prev := v.tree
+	v.tree = tree
+	v.walk(tree.Root, dot)
+	v.tree = prev
+}
+
+// walk visits n with dot being the static type of "." (nil when unknown).
+func (v *validator) walk(n parse.Node, dot reflect.Type) {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			v.walk(c, dot)
+		}
+	case *parse.ActionNode:
+		v.pipe(n.Pipe, dot)
+	case *parse.IfNode:
+		v.pipe(n.Pipe, dot)
+		v.walk(n.List, dot)
+		v.walk(n.ElseList, dot)
+	case *parse.RangeNode:
+		elem := elemType(v.pipe(n.Pipe, dot))
+		v.walk(n.List, elem)
+		v.walk(n.ElseList, dot)
+	case *parse.WithNode:
+		inner := v.pipe(n.Pipe, dot)
+		v.walk(n.List, inner)
+		v.walk(n.ElseList, dot)
+	case *parse.TemplateNode:
+		arg := v.pipe(n.Pipe, dot)
+		if arg == nil {
+			return
+		}
+		for _, tree := range v.trees {
+			if tree.Name == n.Name {
+				v.walkTree(tree, arg)
+			}
+		}
+	}
+}
+
+// pipe checks the fields used in p and returns the pipeline's static type
+// when it is a plain field reference, nil otherwise.
+func (v *validator) pipe(p *parse.PipeNode, dot reflect.Type) reflect.Type {
+	if p == nil {
+		return nil
+	}
+	var last reflect.Type
+	for i, cmd := range p.Cmds {
+		last = nil
+		for _, arg := range cmd.Args {
+			t := v.arg(arg, dot)
+			if i == len(p.Cmds)-1 && len(cmd.Args) == 1 {
+				last = t
+			}
+		}
+	}
+	return last
+}
+
+func (v *validator) arg(n parse.Node, dot reflect.Type) reflect.Type {
+	switch n := n.(type) {
+	case *parse.DotNode:
+		return dot
+	case *parse.FieldNode:
+		return v.field(n, dot, n.Ident)
+	case *parse.VariableNode:
+		if len(n.Ident) > 0 && n.Ident[0] == "$" {
+			return v.field(n, v.root, n.Ident[1:])
+		}
+	case *parse.ChainNode:
+		if base := v.arg(n.Node, dot); base != nil {
+			return v.field(n, base, n.Field)
+		}
+	case *parse.PipeNode:
+		return v.pipe(n, dot)
+	}
+	return nil
+}
+
+func (v *validator) field(n parse.Node, typ reflect.Type, names []string) reflect.Type {
+	for _, name := range names {
+		if typ == nil {
+			return nil
+		}
+		next, ok := lookup(typ, name)
+		if !ok {
+			loc, _ := v.tree.ErrorContext(n)
+			v.problems = append(v.problems, fmt.Sprintf("%s: field %q not found in %s", loc, name, typ))
+			return nil
+		}
+		typ = next
+	}
+	return typ
+}
+
+// lookup resolves name as a field or method on t. The returned type is nil
+// when it cannot be known statically (interfaces).
+func lookup(t reflect.Type, name string) (reflect.Type, bool) {
+	if m, ok := t.MethodByName(name); ok {
+		return outType(m.Type), true
+	}
+	if t.Kind() != reflect.Pointer {
+		if m, ok := reflect.PointerTo(t).MethodByName(name); ok {
+			return outType(m.Type), true
+		}
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if f, ok := t.FieldByName(name); ok && f.IsExported() {
+			return f.Type, true
+		}
+		return nil, false
+	case reflect.Map:
+		return t.Elem(), true
+	case reflect.Interface:
+		return nil, true
+	}
+	return nil, false
+}
+
+func outType(fn reflect.Type) reflect.Type {
+	if fn.NumOut() == 0 {
+		return nil
+	}
+	return fn.Out(0)
+}
+
+func elemType(t reflect.Type) reflect.Type {
+	if t == nil {
+		return nil
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return t.Elem()
+	}
+	return nil
+}
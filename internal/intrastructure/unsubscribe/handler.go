@@ -0,0 +1,77 @@
+package unsubscribe
+
+import (
+	"html/template"
+	"log/slog"
+	"net/http"
+
+	"github.com/Vasenti/stori_challenge/internal/application/ports"
+)
+
+var page = template.Must(template.New("unsubscribe").Parse(`<!doctype html>
+<html lang="en">
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>{{ .Title }}</title></head>
+<body style="font-family:Arial,Helvetica,sans-serif;max-width:480px;margin:48px auto;color:#111827;">
+<h2>{{ .Title }}</h2>
+<p>{{ .Text }}</p>
+{{ if .Confirm }}<form method="post"><button type="submit">Unsubscribe</button></form>{{ end }}
+</body>
+</html>
+`))
+
+type pageData struct {
+	Title   string
+	Text    string
+	Confirm bool
+}
+
+type handler struct {
+	links *Links
+	prefs ports.ReportPreferences
+	log   *slog.Logger
+}
+
+// NewHandler serves the links built by links. POST unsubscribes at once, as
+// RFC 8058 one-click requests from mail clients do; GET only shows a
+// confirmation form, so link scanners that prefetch URLs change nothing.
+func NewHandler(links *Links, prefs ports.ReportPreferences, log *slog.Logger) http.Handler {
+	if log == nil {
+		log = slog.Default()
+	}
+	return &handler{links: links, prefs: prefs, log: log}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	userID := q.Get("u")
+	if !h.links.Verify(userID, q.Get("t")) {
+		h.render(w, http.StatusForbidden, pageData{Title: "Invalid link", Text: "This unsubscribe link is not valid."})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		h.render(w, http.StatusOK, pageData{
+			Title:   "Unsubscribe",
+			Text:    "Stop receiving transaction reports by email?",
+			Confirm: true,
+		})
+	case http.MethodPost:
+		if err := h.prefs.Unsubscribe(r.Context(), userID); err != nil {
+			h.log.ErrorContext(r.Context(), "unsubscribe failed", "user_id", userID, "error", err)
+			h.render(w, http.StatusInternalServerError, pageData{Title: "Something went wrong", Text: "Please try again later."})
+			return
+		}
+		h.render(w, http.StatusOK, pageData{Title: "Unsubscribed", Text: "You will no longer receive transaction reports."})
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *handler) render(w http.ResponseWriter, status int, data pageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = page.Execute(w, data)
+}
@@ -0,0 +1,176 @@
+package unsubscribe
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Vasenti/stori_challenge/internal/application/ports"
+	"github.com/Vasenti/stori_challenge/internal/application/services"
+	"github.com/Vasenti/stori_challenge/internal/domain"
+)
+
+const (
+	testSecret = "s3cret"
+	userID     = "6b1f3c1e-8d2a-4c55-9a7e-0f6a1d2b3c4d"
+)
+
+// users is a UserRepository holding one user.
+type users struct {
+	ports.UserRepository
+	user domain.User
+}
+
+func (u *users) Ensure(context.Context, string) (domain.User, error) { return u.user, nil }
+
+func (u *users) SetReportOptIn(_ context.Context, id string, optIn bool) error {
+	if id == u.user.ID {
+		u.user.ReportOptIn = optIn
+	}
+	return nil
+}
+
+// outbox records what a report run queued.
+type outbox struct {
+	ports.OutboxRepository
+	queued []*domain.OutboxMessage
+}
+
+func (o *outbox) Enqueue(_ context.Context, msg *domain.OutboxMessage) error {
+	o.queued = append(o.queued, msg)
+	return nil
+}
+
+type transactor struct{}
+
+func (transactor) WithinTransaction(ctx context.Context, fn func(context.Context) error) error {
+	return fn(ctx)
+}
+
+func newTestServer(t *testing.T) (*httptest.Server, *Links, *users) {
+	t.Helper()
+	u := &users{user: domain.User{ID: userID, Email: "ana@example.com", ReportOptIn: true}}
+	links := NewLinks(testSecret, "https://reports.example.com/unsubscribe")
+	srv := httptest.NewServer(NewHandler(links, services.NewReportPreferences(u, nil), nil))
+	t.Cleanup(srv.Close)
+	return srv, links, u
+}
+
+// target points the query of link at srv.
+func target(t *testing.T, srv *httptest.Server, link string) string {
+	t.Helper()
+	parsed, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv.URL + "/unsubscribe?" + parsed.RawQuery
+}
+
+func TestLinksVerify(t *testing.T) {
+	links := NewLinks(testSecret, "https://reports.example.com/unsubscribe?src=email")
+	link, err := url.Parse(links.URL(userID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := link.Query()
+	if q.Get("src") != "email" || q.Get("u") != userID {
+		t.Fatalf("URL(%q) = %s: base query or user lost", userID, link)
+	}
+	if !links.Verify(userID, q.Get("t")) {
+		t.Fatalf("Verify rejected the token URL built")
+	}
+	if links.Verify("", Sign(testSecret, "")) {
+		t.Fatalf("Verify accepted an empty user id")
+	}
+}
+
+func TestHandlerRejectsBadTokens(t *testing.T) {
+	srv, _, u := newTestServer(t)
+	token := Sign(testSecret, userID)
+	tests := map[string]string{
+		"tampered token":  "?u=" + userID + "&t=" + strings.Repeat("0", len(token)),
+		"other user":      "?u=" + url.QueryEscape("someone-else") + "&t=" + token,
+		"truncated token": "?u=" + userID + "&t=" + token[:len(token)-2],
+		"rotated secret":  "?u=" + userID + "&t=" + Sign("old-secret", userID),
+		"missing token":   "?u=" + userID,
+		"missing user":    "?t=" + token,
+	}
+	for name, query := range tests {
+		t.Run(name, func(t *testing.T) {
+			for _, method := range []string{http.MethodGet, http.MethodPost} {
+				req, _ := http.NewRequest(method, srv.URL+"/unsubscribe"+query, nil)
+				resp, err := srv.Client().Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusForbidden {
+					t.Errorf("%s: status %d, want 403", method, resp.StatusCode)
+				}
+			}
+			if !u.user.ReportOptIn {
+				t.Fatalf("user opted out by a bad link")
+			}
+		})
+	}
+}
+
+func TestHandlerGetConfirmsWithoutOptingOut(t *testing.T) {
+	srv, links, u := newTestServer(t)
+	resp, err := srv.Client().Get(target(t, srv, links.URL(userID)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), `<form method="post">`) {
+		t.Fatalf("GET = %d %q, want 200 with the confirmation form", resp.StatusCode, body)
+	}
+	if !u.user.ReportOptIn {
+		t.Fatalf("GET opted the user out")
+	}
+}
+
+func TestHandlerPostOptsOutAndReportsAreSkipped(t *testing.T) {
+	srv, links, u := newTestServer(t)
+	// Mail clients send the RFC 8058 one-click POST to the link itself.
+	resp, err := srv.Client().Post(target(t, srv, links.URL(userID)), "application/x-www-form-urlencoded",
+		strings.NewReader("List-Unsubscribe=One-Click"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST = %d, want 200", resp.StatusCode)
+	}
+	if u.user.ReportOptIn {
+		t.Fatalf("POST left the user opted in")
+	}
+
+	queue := &outbox{}
+	svc := services.NewTransactionReportService(nil, u, nil, nil, queue, nil, transactor{}, nil, nil, nil, services.StepTimeouts{}, nil)
+	status, err := svc.Report(context.Background(), u.user.Email, domain.Period{}, ports.ReportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != ports.ReportOptedOut || len(queue.queued) != 0 {
+		t.Fatalf("Report = %q with %d queued, want %q and nothing queued", status, len(queue.queued), ports.ReportOptedOut)
+	}
+}
+
+func TestHandlerRejectsOtherMethods(t *testing.T) {
+	srv, links, _ := newTestServer(t)
+	req, _ := http.NewRequest(http.MethodDelete, target(t, srv, links.URL(userID)), nil)
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("DELETE = %d, want 405", resp.StatusCode)
+	}
+}
@@ -0,0 +1,44 @@
+package unsubscribe
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strings"
+)
+
+// Links builds and verifies the signed one-click unsubscribe URLs put in
+// report emails: <baseURL>?u=<user id>&t=<Sign(secret, user id)>.
+type Links struct {
+	secret  string
+	baseURL string
+}
+
+func NewLinks(secret, baseURL string) *Links {
+	return &Links{secret: secret, baseURL: baseURL}
+}
+
+// URL returns the unsubscribe link of a user.
+func (l *Links) URL(userID string) string {
+	q := url.Values{"u": {userID}, "t": {Sign(l.secret, userID)}}
+	sep := "?"
+	if strings.Contains(l.baseURL, "?") {
+		sep = "&"
+	}
+	return l.baseURL + sep + q.Encode()
+}
+
+// Verify reports whether token was signed for userID with the secret.
+func (l *Links) Verify(userID, token string) bool {
+	return userID != "" && hmac.Equal([]byte(token), []byte(Sign(l.secret, userID)))
+}
+
+// Sign returns hex(HMAC-SHA256(secret, "unsubscribe." + userID)). Links
+// do not expire: an old report must still unsubscribe its reader.
+func Sign(secret, userID string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("unsubscribe."))
+	mac.Write([]byte(userID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,193 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/Vasenti/stori_challenge/internal/application/ports"
+	"github.com/Vasenti/stori_challenge/internal/domain"
+)
+
+const (
+	HeaderSignature = "X-Report-Signature"
+	HeaderTimestamp = "X-Report-Timestamp"
+	HeaderID        = "X-Report-Id"
+)
+
+type Options struct {
+	// Secret signs every request; see Sign.
+	Secret string
+	// Timeout bounds each attempt.
+	Timeout time.Duration
+	// MaxRetries is how many times a failed POST is retried before the
+	// error is returned to the outbox, which retries later on its own.
+	MaxRetries   int
+	RetryBackoff time.Duration
+	// Client defaults to one that refuses to connect to loopback, private
+	// and link-local addresses, so a webhook URL cannot reach internal
+	// services (or the cloud metadata endpoint).
+	Client *http.Client
+	// AllowPrivate lets URLs with private IP literals through; for tests
+	// and receivers on a private network.
+	AllowPrivate bool
+}
+
+// errBlockedAddress is returned for webhook hosts on non-public addresses.
+var errBlockedAddress = errors.New("webhook address is not public")
+
+type Notifier struct {
+	opts Options
+	now  func() time.Time
+}
+
+func NewNotifier(opts Options) *Notifier {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.MaxRetries < 0 {
+		opts.MaxRetries = 0
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = 500 * time.Millisecond
+	}
+	if opts.Client == nil {
+		opts.Client = publicClient()
+	}
+	return &Notifier{opts: opts, now: time.Now}
+}
+
+// Notify POSTs the JSON payload to the notification's URL. Network errors,
+// 429 and 5xx responses are retried with exponential backoff; other
+// non-2xx responses fail immediately with domain.ErrValidation, so the
+// outbox dead-letters them. Nothing is sent without a secret to sign with,
+// or to a URL that is not https.
+func (n *Notifier) Notify(ctx context.Context, msg ports.Notification) error {
+	if n.opts.Secret == "" {
+		return domain.Errorf(domain.ErrValidation, "webhook secret is not configured (WEBHOOK_SECRET)")
+	}
+	if err := n.checkURL(msg.WebhookURL); err != nil {
+		return err
+	}
+	wait := n.opts.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := n.post(ctx, msg)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= n.opts.MaxRetries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+func (n *Notifier) post(ctx context.Context, msg ports.Notification) (retry bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, n.opts.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, msg.WebhookURL, bytes.NewReader(msg.Payload))
+	if err != nil {
+		return false, err
+	}
+	ts := n.now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(ts, 10))
+	req.Header.Set(HeaderSignature, Sign(n.opts.Secret, ts, msg.Payload))
+	if msg.ID != "" {
+		req.Header.Set(HeaderID, msg.ID)
+	}
+
+	resp, err := n.opts.Client.Do(req)
+	if errors.Is(err, errBlockedAddress) {
+		return false, domain.Wrap("post webhook", domain.ErrValidation, err)
+	}
+	if err != nil {
+		return true, fmt.Errorf("post webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return true, fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return false, domain.Errorf(domain.ErrValidation, "webhook responded %s", resp.Status)
+}
+
+// checkURL requires an https URL whose host, when it is an IP literal, is
+// public (unless AllowPrivate). Hostnames are checked when dialled.
+func (n *Notifier) checkURL(raw string) error {
+	if raw == "" {
+		return domain.Errorf(domain.ErrValidation, "webhook url is empty")
+	}
+	if _, err := domain.ParseWebhookURL(raw); err != nil {
+		return err
+	}
+	u, _ := url.Parse(raw)
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !n.opts.AllowPrivate && !public(ip) {
+		return domain.Wrap(raw, domain.ErrValidation, errBlockedAddress)
+	}
+	return nil
+}
+
+// publicClient is an HTTP client that only connects to public addresses.
+// The check runs on the resolved address, so DNS names pointing inside the
+// network are refused too. Proxies are not used, as they would be the
+// address checked.
+func publicClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !public(ip) {
+				return errBlockedAddress
+			}
+			return nil
+		},
+	}
+	return &http.Client{Transport: &http.Transport{
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+		MaxIdleConns:        10,
+		IdleConnTimeout:     90 * time.Second,
+	}}
+}
+
+func public(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
+
+// Sign returns the signature header value for body sent at timestamp:
+// "sha256=" + hex(HMAC-SHA256(secret, "<timestamp>.<body>")). Receivers
+// recompute it and compare with hmac.Equal.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,188 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Vasenti/stori_challenge/internal/application/ports"
+	"github.com/Vasenti/stori_challenge/internal/domain"
+)
+
+const testSecret = "s3cret"
+
+// receiver records the requests a test server got and answers them with
+// statuses in turn (the last one repeats).
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	times    []time.Time
+	reqs     []*http.Request
+	bodies   [][]byte
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := len(r.reqs)
+	r.reqs = append(r.reqs, req)
+	r.bodies = append(r.bodies, body)
+	r.times = append(r.times, time.Now())
+	w.WriteHeader(r.statuses[min(n, len(r.statuses)-1)])
+}
+
+func (r *receiver) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.reqs)
+}
+
+func newTestNotifier(t *testing.T, statuses ...int) (*Notifier, *receiver, string) {
+	t.Helper()
+	rec := &receiver{statuses: statuses}
+	srv := httptest.NewTLSServer(rec)
+	t.Cleanup(srv.Close)
+	n := NewNotifier(Options{
+		Secret:       testSecret,
+		MaxRetries:   2,
+		RetryBackoff: 20 * time.Millisecond,
+		Client:       srv.Client(),
+		AllowPrivate: true,
+	})
+	return n, rec, srv.URL + "/hook"
+}
+
+func notification(url string) ports.Notification {
+	return ports.Notification{ID: "42", WebhookURL: url, Payload: []byte(`{"balance_total":10}`)}
+}
+
+func TestNotifySignsTheRequest(t *testing.T) {
+	n, rec, url := newTestNotifier(t, http.StatusNoContent)
+	n.now = func() time.Time { return time.Unix(1700000000, 0) }
+
+	if err := n.Notify(context.Background(), notification(url)); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if rec.count() != 1 {
+		t.Fatalf("requests = %d, want 1", rec.count())
+	}
+	req, body := rec.reqs[0], rec.bodies[0]
+	if got := req.Header.Get(HeaderTimestamp); got != "1700000000" {
+		t.Errorf("timestamp header = %q", got)
+	}
+	ts, _ := strconv.ParseInt(req.Header.Get(HeaderTimestamp), 10, 64)
+	if got, want := req.Header.Get(HeaderSignature), Sign(testSecret, ts, body); got != want {
+		t.Errorf("signature header = %q, want %q", got, want)
+	}
+	if got := req.Header.Get(HeaderID); got != "42" {
+		t.Errorf("id header = %q", got)
+	}
+	if Sign("other", ts, body) == req.Header.Get(HeaderSignature) {
+		t.Error("signature does not depend on the secret")
+	}
+}
+
+func TestNotifyRetriesWithBackoff(t *testing.T) {
+	for _, status := range []int{http.StatusServiceUnavailable, http.StatusTooManyRequests} {
+		t.Run(strconv.Itoa(status), func(t *testing.T) {
+			n, rec, url := newTestNotifier(t, status, status, http.StatusOK)
+
+			if err := n.Notify(context.Background(), notification(url)); err != nil {
+				t.Fatalf("Notify: %v", err)
+			}
+			if rec.count() != 3 {
+				t.Fatalf("requests = %d, want 3", rec.count())
+			}
+			first, second := rec.times[1].Sub(rec.times[0]), rec.times[2].Sub(rec.times[1])
+			if first < 20*time.Millisecond || second < 40*time.Millisecond {
+				t.Errorf("waits = %v, %v; want >= 20ms then >= 40ms", first, second)
+			}
+		})
+	}
+}
+
+func TestNotifyGivesUpAfterMaxRetries(t *testing.T) {
+	n, rec, url := newTestNotifier(t, http.StatusBadGateway)
+
+	err := n.Notify(context.Background(), notification(url))
+	if err == nil || errors.Is(err, domain.ErrValidation) {
+		t.Fatalf("err = %v, want a retryable error", err)
+	}
+	if rec.count() != 3 {
+		t.Errorf("requests = %d, want 1 + 2 retries", rec.count())
+	}
+}
+
+func TestNotifyDoesNotRetryClientErrors(t *testing.T) {
+	n, rec, url := newTestNotifier(t, http.StatusBadRequest)
+
+	err := n.Notify(context.Background(), notification(url))
+	if !errors.Is(err, domain.ErrValidation) {
+		t.Fatalf("err = %v, want domain.ErrValidation", err)
+	}
+	if rec.count() != 1 {
+		t.Errorf("requests = %d, want 1", rec.count())
+	}
+}
+
+func TestNotifyHonoursCancellation(t *testing.T) {
+	n, rec, url := newTestNotifier(t, http.StatusServiceUnavailable)
+	n.opts.RetryBackoff = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- n.Notify(ctx, notification(url)) }()
+
+	for rec.count() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("err = %v, want context.Canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Notify kept waiting after ctx was canceled")
+	}
+	if rec.count() != 1 {
+		t.Errorf("requests = %d, want 1", rec.count())
+	}
+}
+
+func TestNotifyRefusesUnsafeDeliveries(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		t.Error("request reached the server")
+	}))
+	defer srv.Close()
+	localhost := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+
+	cases := []struct {
+		name string
+		opts Options
+		url  string
+	}{
+		{"no secret", Options{}, srv.URL},
+		{"http", Options{Secret: testSecret, AllowPrivate: true}, strings.Replace(srv.URL, "https", "http", 1)},
+		{"no host", Options{Secret: testSecret}, "https:///hook"},
+		{"private ip", Options{Secret: testSecret}, srv.URL},
+		{"metadata ip", Options{Secret: testSecret}, "https://169.254.169.254/latest"},
+		{"name resolving to loopback", Options{Secret: testSecret}, localhost},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := NewNotifier(c.opts).Notify(context.Background(), notification(c.url))
+			if !errors.Is(err, domain.ErrValidation) {
+				t.Errorf("err = %v, want domain.ErrValidation", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Gorm adapts slog to GORM's logger so queries are logged with the caller's
+// correlation id: failed queries at error, queries slower than slow at warn
+// and every query at debug.
+func Gorm(l *slog.Logger, slow time.Duration) logger.Interface {
+	return gormLogger{l: l, slow: slow}
+}
+
+type gormLogger struct {
+	l    *slog.Logger
+	slow time.Duration
+}
+
+func (g gormLogger) LogMode(logger.LogLevel) logger.Interface { return g }
+
+func (g gormLogger) Info(ctx context.Context, msg string, args ...any) {
+	g.l.InfoContext(ctx, msg, "args", args)
+}
+
+func (g gormLogger) Warn(ctx context.Context, msg string, args ...any) {
+	g.l.WarnContext(ctx, msg, "args", args)
+}
+
+func (g gormLogger) Error(ctx context.Context, msg string, args ...any) {
+	g.l.ErrorContext(ctx, msg, "args", args)
+}
+
+func (g gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	elapsed := time.Since(begin)
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+		sql, rows := fc()
+		g.l.ErrorContext(ctx, "db query failed", "sql", sql, "rows", rows, "elapsed", elapsed, "error", err)
+	case g.slow > 0 && elapsed > g.slow:
+		sql, rows := fc()
+		g.l.WarnContext(ctx, "db slow query", "sql", sql, "rows", rows, "elapsed", elapsed)
+	case g.l.Enabled(ctx, slog.LevelDebug):
+		sql, rows := fc()
+		g.l.DebugContext(ctx, "db query", "sql", sql, "rows", rows, "elapsed", elapsed)
+	}
+}
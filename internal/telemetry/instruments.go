@@ -0,0 +1,83 @@
+package telemetry
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Instruments are created on the global meter, which forwards to the
+// provider installed by Setup even when they are created before it.
+var (
+	meter = otel.Meter(scope)
+
+	rowsParsed, _ = meter.Int64Counter("report.rows.parsed",
+		metric.WithDescription("Transactions parsed from CSV sources"), metric.WithUnit("{row}"))
+	rowsInserted, _ = meter.Int64Counter("report.rows.inserted",
+		metric.WithDescription("Transactions inserted (duplicates excluded)"), metric.WithUnit("{row}"))
+	deliveries, _ = meter.Int64Counter("report.deliveries",
+		metric.WithDescription("Report delivery attempts by channel and result (sent, failed, dead)"))
+	stepDuration, _ = meter.Float64Histogram("report.step.duration",
+		metric.WithDescription("Duration of each report pipeline step"), metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30))
+)
+
+func Tracer() trace.Tracer { return otel.Tracer(scope) }
+
+func RowsParsed(ctx context.Context, n int) {
+	rowsParsed.Add(ctx, int64(n))
+}
+
+func RowsInserted(ctx context.Context, n int64) {
+	rowsInserted.Add(ctx, n)
+}
+
+// Delivered counts a delivery attempt; result is "sent", "failed" or "dead".
+func Delivered(ctx context.Context, channel, result string) {
+	deliveries.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("channel", channel), attribute.String("result", result)))
+}
+
+// Span wraps a trace span; spans started with StartStep also record their
+// duration in the report.step.duration histogram.
+type Span struct {
+	span  trace.Span
+	step  string
+	start time.Time
+}
+
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, *Span) {
+	ctx, span := Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+	return ctx, &Span{span: span, start: time.Now()}
+}
+
+// StartStep starts the span of a pipeline step, named "report.<step>".
+func StartStep(ctx context.Context, step string, attrs ...attribute.KeyValue) (context.Context, *Span) {
+	ctx, s := Start(ctx, "report."+step, attrs...)
+	s.step = step
+	return ctx, s
+}
+
+func (s *Span) SetAttributes(attrs ...attribute.KeyValue) {
+	s.span.SetAttributes(attrs...)
+}
+
+// End marks the span failed when err is not nil and ends it.
+func (s *Span) End(err error) {
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	if s.step != "" {
+		stepDuration.Record(context.Background(), time.Since(s.start).Seconds(), metric.WithAttributes(
+			attribute.String("step", s.step), attribute.String("outcome", outcome)))
+	}
+	s.span.End()
+}
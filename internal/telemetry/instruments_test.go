@@ -0,0 +1,132 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// The instruments forward to the global providers, which can only be
+// installed once per process, so every test shares these.
+var (
+	spans   = tracetest.NewInMemoryExporter()
+	metrics = sdkmetric.NewManualReader()
+)
+
+func TestMain(m *testing.M) {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(spans)))
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(metrics)))
+	os.Exit(m.Run())
+}
+
+func TestSpanEndRecordsTheError(t *testing.T) {
+	spans.Reset()
+
+	_, ok := Start(context.Background(), "report.process", attribute.String("user", "ana@example.com"))
+	ok.End(nil)
+	_, failed := StartStep(context.Background(), "parse")
+	failed.End(errors.New("bad row"))
+
+	got := spans.GetSpans()
+	if len(got) != 2 {
+		t.Fatalf("spans = %d, want 2", len(got))
+	}
+	if got[0].Name != "report.process" || got[0].Status.Code != codes.Unset {
+		t.Errorf("span 0 = %s %v, want report.process with no error", got[0].Name, got[0].Status)
+	}
+	if got[1].Name != "report.parse" || got[1].Status.Code != codes.Error || got[1].Status.Description != "bad row" {
+		t.Errorf("span 1 = %s %v, want report.parse with error status", got[1].Name, got[1].Status)
+	}
+	if len(got[1].Events) != 1 || got[1].Events[0].Name != "exception" {
+		t.Errorf("span 1 events = %v, want the recorded error", got[1].Events)
+	}
+}
+
+func TestStepAndOutboxMetrics(t *testing.T) {
+	ctx := context.Background()
+	_, s := StartStep(ctx, "upsert")
+	s.End(nil)
+	_, s = StartStep(ctx, "upsert")
+	s.End(errors.New("db down"))
+	Delivered(ctx, "webhook", "sent")
+	Delivered(ctx, "webhook", "sent")
+	Delivered(ctx, "email", "dead")
+	RowsParsed(ctx, 4)
+	RowsInserted(ctx, 3)
+
+	var rm metricdata.ResourceMetrics
+	if err := metrics.Collect(ctx, &rm); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := histogram(t, rm, "report.step.duration")
+	if n := steps[attribute.NewSet(attribute.String("step", "upsert"), attribute.String("outcome", "ok"))]; n != 1 {
+		t.Errorf("ok upserts = %d, want 1", n)
+	}
+	if n := steps[attribute.NewSet(attribute.String("step", "upsert"), attribute.String("outcome", "error"))]; n != 1 {
+		t.Errorf("failed upserts = %d, want 1", n)
+	}
+
+	sent := counter(t, rm, "report.deliveries")
+	if n := sent[attribute.NewSet(attribute.String("channel", "webhook"), attribute.String("result", "sent"))]; n != 2 {
+		t.Errorf("webhook sent = %d, want 2", n)
+	}
+	if n := sent[attribute.NewSet(attribute.String("channel", "email"), attribute.String("result", "dead"))]; n != 1 {
+		t.Errorf("email dead = %d, want 1", n)
+	}
+	if n := counter(t, rm, "report.rows.parsed")[*attribute.EmptySet()]; n != 4 {
+		t.Errorf("rows parsed = %d, want 4", n)
+	}
+	if n := counter(t, rm, "report.rows.inserted")[*attribute.EmptySet()]; n != 3 {
+		t.Errorf("rows inserted = %d, want 3", n)
+	}
+}
+
+func find(t *testing.T, rm metricdata.ResourceMetrics, name string) metricdata.Metrics {
+	t.Helper()
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name == name {
+				return m
+			}
+		}
+	}
+	t.Fatalf("metric %s not collected", name)
+	return metricdata.Metrics{}
+}
+
+func counter(t *testing.T, rm metricdata.ResourceMetrics, name string) map[attribute.Set]int64 {
+	t.Helper()
+	sum, ok := find(t, rm, name).Data.(metricdata.Sum[int64])
+	if !ok {
+		t.Fatalf("%s is not an int64 sum", name)
+	}
+	out := map[attribute.Set]int64{}
+	for _, dp := range sum.DataPoints {
+		out[dp.Attributes] = dp.Value
+	}
+	return out
+}
+
+// histogram returns the number of recordings per attribute set.
+func histogram(t *testing.T, rm metricdata.ResourceMetrics, name string) map[attribute.Set]uint64 {
+	t.Helper()
+	h, ok := find(t, rm, name).Data.(metricdata.Histogram[float64])
+	if !ok {
+		t.Fatalf("%s is not a float64 histogram", name)
+	}
+	out := map[attribute.Set]uint64{}
+	for _, dp := range h.DataPoints {
+		out[dp.Attributes] = dp.Count
+	}
+	return out
+}
@@ -0,0 +1,110 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	otelprom "go.opentelemetry.io/otel/exporters/prometheus"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// scope names the tracer and meter of every span and instrument in the pipeline.
+const scope = "github.com/Vasenti/stori_challenge"
+
+type Options struct {
+	ServiceName string
+	// OTLP exports traces and metrics over OTLP/HTTP; the exporters read the
+	// standard OTEL_EXPORTER_OTLP_* variables (endpoint, headers, ...).
+	OTLP bool
+	// Prometheus collects metrics for scraping through Provider.Handler.
+	Prometheus bool
+}
+
+// Provider owns the SDK providers installed by Setup. With no exporter
+// enabled it is inert and the global no-op providers stay in place.
+type Provider struct {
+	tp       *sdktrace.TracerProvider
+	mp       *sdkmetric.MeterProvider
+	registry *prometheus.Registry
+}
+
+// Setup installs the global tracer and meter providers for the enabled exporters.
+func Setup(ctx context.Context, opts Options) (*Provider, error) {
+	p := &Provider{}
+	if !opts.OTLP && !opts.Prometheus {
+		return p, nil
+	}
+
+	res, err := resource.Merge(resource.Default(),
+		resource.NewSchemaless(attribute.String("service.name", opts.ServiceName)))
+	if err != nil {
+		return nil, err
+	}
+
+	mopts := []sdkmetric.Option{sdkmetric.WithResource(res)}
+	if opts.OTLP {
+		texp, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, err
+		}
+		p.tp = sdktrace.NewTracerProvider(sdktrace.WithBatcher(texp), sdktrace.WithResource(res))
+		otel.SetTracerProvider(p.tp)
+
+		mexp, err := otlpmetrichttp.New(ctx)
+		if err != nil {
+			return nil, err
+		}
+		mopts = append(mopts, sdkmetric.WithReader(sdkmetric.NewPeriodicReader(mexp)))
+	}
+	if opts.Prometheus {
+		p.registry = prometheus.NewRegistry()
+		pexp, err := otelprom.New(otelprom.WithRegisterer(p.registry))
+		if err != nil {
+			return nil, err
+		}
+		mopts = append(mopts, sdkmetric.WithReader(pexp))
+	}
+	p.mp = sdkmetric.NewMeterProvider(mopts...)
+	otel.SetMeterProvider(p.mp)
+	return p, nil
+}
+
+// Handler serves the Prometheus metrics, or 404 when Prometheus is disabled.
+func (p *Provider) Handler() http.Handler {
+	if p.registry == nil {
+		return http.NotFoundHandler()
+	}
+	return promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{})
+}
+
+// ForceFlush pushes buffered spans and metrics, e.g. before a Lambda freezes.
+func (p *Provider) ForceFlush(ctx context.Context) error {
+	var errs []error
+	if p.tp != nil {
+		errs = append(errs, p.tp.ForceFlush(ctx))
+	}
+	if p.mp != nil {
+		errs = append(errs, p.mp.ForceFlush(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+func (p *Provider) Shutdown(ctx context.Context) error {
+	var errs []error
+	if p.tp != nil {
+		errs = append(errs, p.tp.Shutdown(ctx))
+	}
+	if p.mp != nil {
+		errs = append(errs, p.mp.Shutdown(ctx))
+	}
+	return errors.Join(errs...)
+}